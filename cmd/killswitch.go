@@ -0,0 +1,25 @@
+//go:build !windows
+
+package cmd
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/rb3ckers/trafficmirror/internal/proxy"
+)
+
+// Kill switch: SIGUSR1 stops all mirroring, SIGUSR2 enables it again
+func handleKillSwitch(p *proxy.Proxy) {
+	killSwitch := make(chan os.Signal, 1)
+	signal.Notify(killSwitch, syscall.SIGUSR1, syscall.SIGUSR2)
+
+	go func() {
+		for sig := range killSwitch {
+			log.Printf("Received signal '%s'\n", sig)
+			p.SetMirroringEnabled(sig == syscall.SIGUSR2)
+		}
+	}()
+}
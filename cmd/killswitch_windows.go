@@ -0,0 +1,8 @@
+package cmd
+
+import (
+	"github.com/rb3ckers/trafficmirror/internal/proxy"
+)
+
+// Windows has no SIGUSR1 and SIGUSR2, mirroring can still be switched off via the targets endpoint
+func handleKillSwitch(p *proxy.Proxy) {}
@@ -76,6 +76,24 @@ Additional targets are configured via PUT/DELETE on the '/targets?url=<endpoint>
 	cmd.Flags().Int("main-target-delay-ms", 0, "Delay delivery to main target, allowing slower mirrors to keep up and increase discovered parallelism.") //nolint:gomnd
 	cmd.Flags().Int("retry-after", 1, "After 5 successive failures a target is temporarily disabled, it will be retried after this many minutes.")
 	cmd.Flags().Bool("enable-pprof", false, "Enable pprof.")
+	cmd.Flags().Bool("quarantine", false, "Quarantine a target instead of removing it when it has been failing for 'fail-after' minutes. Quarantined targets are health checked and rejoin when healthy.")
+	cmd.Flags().String("health-check-path", "/", "Path that is probed on a quarantined target to determine whether it is healthy again.")
+	cmd.Flags().Int("health-check-interval", 10, "Probe a quarantined target every this many seconds.")                                               //nolint:gomnd
+	cmd.Flags().Int("max-quarantine", 0, "Remove a target when it has been quarantined for this many minutes, 0 keeps it quarantined until healthy.") //nolint:gomnd
+	cmd.Flags().String("mirror-proxy", "", "Upstream proxy for mirrored requests of targets without their own proxy, either http://[user:password@]host:port or socks5://[user:password@]host:port.")
+	cmd.Flags().Bool("mirror-proxy-from-environment", false, "Use the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables for mirrored requests of targets without a proxy.")
+	cmd.Flags().String("discovery-file", "", "Discover targets from this JSON or YAML file (Prometheus file_sd format), the file is watched for changes.")
+	cmd.Flags().Int("discovery-file-interval", 10, "Check the discovery file for changes every this many seconds.") //nolint:gomnd
+	cmd.Flags().StringSlice("discovery-srv", []string{}, "Discover targets from these DNS SRV records, e.g. '_http._tcp.shadow.example.com'.")
+	cmd.Flags().String("discovery-srv-scheme", "http", "Scheme of the targets discovered from DNS SRV records.")
+	cmd.Flags().Int("discovery-srv-interval", 30, "Look up the DNS SRV records every this many seconds.") //nolint:gomnd
+	cmd.Flags().String("delay-dir", "", "Directory holding the backlog of targets with a delay, one sub directory per target. Defaults to a directory in the system temp directory.")
+	cmd.Flags().String("control-header", "", "Request header with which a request can opt out of mirroring, e.g. 'X-Mirror' with the value 'off'. The header is not forwarded.")
+	cmd.Flags().String("control-targets-header", "", "Request header that limits a request to a subset of the targets, e.g. 'X-Mirror-Targets' with target URLs or a label selector as value. The header is not forwarded.")
+	cmd.Flags().String("correlation-header", "", "Header with an ID that joins a request to its mirrored copies, e.g. 'X-Request-Id'. An existing ID is reused, otherwise one is generated and added to the main and mirrored requests.")
+	cmd.Flags().String("hop-header", "X-Mirrored-By", "Header that marks mirrored requests, requests that already carry it are not mirrored again. Empty disables the marker.")
+	cmd.Flags().String("hop-id", "", "Value of the hop header, defaults to the host name.")
+	cmd.Flags().String("secrets-dir", "", "Directory with the token, key and secret files that targets added via the targets endpoint can refer to by name. Without it such files can only be set in the config file.")
 	cmd.Flags().StringSlice("mirror", []string{}, "Start with mirroring traffic to provided targets")
 
 	return cmd
@@ -89,6 +107,8 @@ func RunProxy(cfg *config.Config) error {
 
 	p := proxy.NewProxy(cfg)
 
+	handleKillSwitch(p)
+
 	go func() {
 		sig := <-sigs
 		log.Printf("Received signal '%s', exiting\n", sig)
@@ -15,9 +15,28 @@ type Config struct {
 	PersistentFailureTimeout int      `yaml:"fail-after" default:"30"`
 	RetryAfter               int      `yaml:"retry-after" default:"1"`
 	Mirrors                  []string `yaml:"mirror"`
+	Targets                  []Target `yaml:"mirror-targets"`
 	MaxQueuedRequests        int      `yaml:"max-queued-requests" default:"500"`
 	MainTargetDelayMs        int      `yaml:"main-target-delay-ms" default:"0"`
 	EnablePProf              bool     `yaml:"enable-pprof" default:"false"`
+	Quarantine               bool     `yaml:"quarantine" default:"false"`
+	HealthCheckPath          string   `yaml:"health-check-path" default:"/"`
+	HealthCheckInterval      int      `yaml:"health-check-interval" default:"10"`
+	MaxQuarantine            int      `yaml:"max-quarantine" default:"0"`
+	MirrorProxy              string   `yaml:"mirror-proxy"`
+	MirrorProxyFromEnv       bool     `yaml:"mirror-proxy-from-environment" default:"false"`
+	DiscoveryFile            string   `yaml:"discovery-file"`
+	DiscoveryFileInterval    int      `yaml:"discovery-file-interval" default:"10"`
+	DiscoverySRV             []string `yaml:"discovery-srv"`
+	DiscoverySRVScheme       string   `yaml:"discovery-srv-scheme" default:"http"`
+	DiscoverySRVInterval     int      `yaml:"discovery-srv-interval" default:"30"`
+	DelayDir                 string   `yaml:"delay-dir"`
+	ControlHeader            string   `yaml:"control-header"`
+	ControlTargetsHeader     string   `yaml:"control-targets-header"`
+	CorrelationHeader        string   `yaml:"correlation-header"`
+	HopHeader                string   `yaml:"hop-header" default:"X-Mirrored-By"`
+	HopID                    string   `yaml:"hop-id"`
+	SecretsDir               string   `yaml:"secrets-dir"`
 }
 
 func (s *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
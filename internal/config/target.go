@@ -0,0 +1,258 @@
+package config
+
+import "time"
+
+// Target is a mirror target together with its target specific settings. Targets can be configured in the
+// configuration file under 'mirror-targets' or added at runtime via the targets endpoint.
+type Target struct {
+	URL string `yaml:"url"`
+	// A target group has a name and members instead of a URL, every request is sent to one of the members
+	Name    string   `yaml:"name"`
+	Members []string `yaml:"members"`
+	// How the member is picked: 'round-robin' (default), 'least-in-flight' or 'hash'
+	Balance string `yaml:"balance"`
+	// What is hashed with the 'hash' balancing: 'header:<name>', 'query:<name>', 'path' or 'remote-addr' (default)
+	HashKey string `yaml:"hash-key"`
+	// Eject a member after this many consecutive failures (default 3)
+	EjectAfter int `yaml:"eject-after"`
+
+	// Labels are used to select targets, e.g. team=payments
+	Labels map[string]string `yaml:"labels"`
+	// Descriptive information, e.g. owner, ticket or description
+	Metadata   map[string]string `yaml:"metadata"`
+	Persistent bool              `yaml:"persistent"`
+	// What happens to requests while the target is paused: 'buffer' (default) queues them, 'skip' drops them
+	PauseMode string `yaml:"pause-mode"`
+	// Remove the target after this time
+	TTL time.Duration `yaml:"ttl"`
+	// Remove the target after this many mirrored requests
+	MaxRequests int `yaml:"max-requests"`
+	// Send the requests this long after they were received, the backlog is kept on disk
+	Delay time.Duration `yaml:"delay"`
+	// Requests over this many in the backlog are dropped (default 10000)
+	DelayMaxBacklog int `yaml:"delay-max-backlog"`
+	// Due requests sent at the same time (default 1, which keeps the order of arrival)
+	DelayConcurrency int `yaml:"delay-concurrency"`
+	// WebSocket connections are mirrored with 'mirror', which discards the frames of the target, or 'compare', which
+	// compares its messages to those of the main target. By default they are not mirrored.
+	WebSocket string `yaml:"websocket"`
+	// Probe the target before it is added: 'http' requests PreflightPath (default health-check-path), 'tcp' connects
+	Preflight     string `yaml:"preflight"`
+	PreflightPath string `yaml:"preflight-path"`
+
+	Retry     RetryPolicy   `yaml:"retry"`
+	Client    ClientProfile `yaml:"client"`
+	DNS       DNSSettings   `yaml:"dns"`
+	RateLimit RateLimit     `yaml:"rate-limit"`
+	Ramp      Ramp          `yaml:"ramp"`
+	Amplify   Amplification `yaml:"amplify"`
+	// Conditions on the response of the main target
+	MainResponse MainResponseConditions `yaml:"main-response"`
+	Redact       RedactRules            `yaml:"redact"`
+	Credentials  Credentials            `yaml:"credentials"`
+	Sign         Signing                `yaml:"sign"`
+	CookieJar    CookieJar              `yaml:"cookie-jar"`
+	IDMapping    IDMapping              `yaml:"id-mapping"`
+}
+
+// IDMapping maps the IDs of resources created by the main target to the IDs the target created for them, and
+// rewrites them in later requests.
+type IDMapping struct {
+	Capture []IDCapture `yaml:"capture"`
+	// Where IDs are rewritten: 'path', 'query' and 'json' (default all)
+	Rewrite []string `yaml:"rewrite"`
+	// Mappings that are not used for this long are forgotten (default 1h)
+	IdleTimeout time.Duration `yaml:"idle-timeout"`
+	// Maximum number of mappings (default 100000), the least recently used are forgotten first
+	MaxEntries int `yaml:"max-entries"`
+}
+
+// IDCapture captures an ID from the responses of the main target and the mirror to the same request.
+type IDCapture struct {
+	// Either 'location' for the last path segment of the Location header, or a dotted JSON path into the body
+	From string `yaml:"from"`
+	// Only responses to requests with this method, e.g. 'POST' (default all)
+	Method string `yaml:"method"`
+	// Only responses to requests of which the path starts with this prefix
+	Path string `yaml:"path"`
+}
+
+// CookieJar maps the production cookies of mirrored requests to the cookies the target issued for them.
+type CookieJar struct {
+	Enabled bool `yaml:"enabled"`
+	// Names of the cookies that are mapped, all cookies when empty
+	Cookies []string `yaml:"cookies"`
+	// Mappings that are not used for this long are forgotten (default 30m)
+	IdleTimeout time.Duration `yaml:"idle-timeout"`
+	// Maximum number of mappings (default 10000), the least recently used are forgotten first
+	MaxEntries int `yaml:"max-entries"`
+}
+
+// Signing re-signs mirrored requests, after all other changes to the request.
+type Signing struct {
+	// Either 'hmac' or 'aws-sigv4'
+	Scheme string `yaml:"scheme"`
+	// File with the HMAC secret or the AWS secret access key
+	SecretFile string `yaml:"secret-file"`
+	// HMAC: the header receiving the signature (default X-Signature)
+	Header string `yaml:"header"`
+	// HMAC: what is signed, with placeholders like {method}, {path}, {timestamp} and {body}
+	Template string `yaml:"template"`
+	// HMAC: the value of the signature header, with {signature} and the placeholders of the template
+	Format string `yaml:"format"`
+	// HMAC: header that receives the timestamp
+	TimestampHeader string `yaml:"timestamp-header"`
+	// HMAC: 'sha256' (default), 'sha1' or 'sha512'
+	Hash string `yaml:"hash"`
+	// HMAC: 'hex' (default) or 'base64'
+	Encoding string `yaml:"encoding"`
+	// AWS: region, service and access key ID, the keys default to the AWS environment variables
+	Region      string `yaml:"region"`
+	Service     string `yaml:"service"`
+	AccessKeyID string `yaml:"access-key-id"`
+}
+
+// Credentials control the credentials the target receives in place of the production ones.
+type Credentials struct {
+	// 'pass' (default) forwards them, 'strip' removes them, 'static' replaces them with the token in TokenFile and
+	// 'remint' re-signs JWT bearer tokens with the key in KeyFile, keeping their claims
+	Mode string `yaml:"mode"`
+	// Header carrying the credentials (default Authorization)
+	Header string `yaml:"header"`
+	// File with the static token, sent as bearer token unless it contains a scheme like 'Basic ...'
+	TokenFile string `yaml:"token-file"`
+	// Either 'HS256' (default) with a secret in KeyFile, or 'RS256' with a PEM encoded RSA private key
+	Algorithm string `yaml:"algorithm"`
+	KeyFile   string `yaml:"key-file"`
+	// Key ID set in the header of re-minted tokens
+	KeyID string `yaml:"key-id"`
+	// Re-minted tokens are valid this long from when they are minted, 0 keeps the original expiry
+	TTL time.Duration `yaml:"ttl"`
+}
+
+// RedactRules remove or mask sensitive data in the requests before they are mirrored to the target.
+type RedactRules struct {
+	// Headers that are removed
+	DropHeaders []string `yaml:"drop-headers"`
+	// Headers of which the values are replaced by a hash, so they can still be correlated
+	HashHeaders []string `yaml:"hash-headers"`
+	// Fields of JSON bodies that are masked, as dotted paths where '*' matches any key or array element: 'user.email'
+	JSONPaths []string `yaml:"json-paths"`
+	// Fields of form bodies and the query string that are masked
+	FormFields []string `yaml:"form-fields"`
+	// Regular expressions that are masked in the body, query string and headers, or 'email' and 'card' for the
+	// built in patterns
+	Patterns []string `yaml:"patterns"`
+	// Either 'mask' (default) to replace values with REDACTED, or 'fake' to replace them with a fake value of the same
+	// format
+	Mode string `yaml:"mode"`
+	// Key for the hashes and fake values, without it they can be reversed by trying likely values
+	Secret string `yaml:"secret"`
+}
+
+// MainResponseConditions decide whether a request is mirrored based on how the main target responded to it.
+type MainResponseConditions struct {
+	// Only mirror when the main target responded with one of these, e.g. '2xx' or '404'. Empty mirrors any status.
+	Status []string `yaml:"status"`
+	// Do not mirror when the main target responded with one of these, e.g. '5xx'
+	SkipStatus []string `yaml:"skip-status"`
+	// Do not mirror when the client disconnected before the main target responded
+	SkipDisconnected bool `yaml:"skip-disconnected"`
+	// Send the status and latency of the main response along as X-Main-Status and X-Main-Latency headers
+	ForwardHeaders bool `yaml:"forward-headers"`
+}
+
+// Amplification sends every mirrored request multiple times, to load test a target with production traffic.
+type Amplification struct {
+	// Number of times every request is sent, 0 or 1 sends it once
+	Copies int `yaml:"copies"`
+	// Either 'sequential' (default) or 'concurrent'
+	Mode string `yaml:"mode"`
+	// Header carrying the index of the copy, starting at 1 (default X-Mirror-Copy)
+	Header string `yaml:"header"`
+}
+
+// Ramp gradually increases the share of the requests that is sent to a newly added target.
+type Ramp struct {
+	// Time to go from the start percentage to all requests, 0 disables the ramp
+	Duration time.Duration `yaml:"duration"`
+	// Percentage of the requests sent at the start
+	From float64 `yaml:"from"`
+	// What happens when the ramp is aborted: 'hold' (default) keeps the current percentage, 'rollback' goes back to From
+	OnFailure string `yaml:"on-failure"`
+	// Abort the ramp when this percentage of the requests fails, 0 only aborts when the circuit breaker opens
+	MaxErrorRate float64 `yaml:"max-error-rate"`
+}
+
+// RateLimit limits the traffic sent to a target. Zero values disable the limit.
+type RateLimit struct {
+	// Requests per second
+	Requests float64 `yaml:"requests"`
+	// Request body bytes per second
+	Bytes int `yaml:"bytes"`
+	// What happens to requests over the rate: 'delay' (default) queues them, 'drop' drops them
+	Mode string `yaml:"mode"`
+	// Pause the target when this many requests were sent in the current hour or day, until the next one starts
+	HourlyQuota int `yaml:"hourly-quota"`
+	DailyQuota  int `yaml:"daily-quota"`
+}
+
+// RetryPolicy describes if and how a failed mirrored request is retried. Zero values fall back to the defaults.
+type RetryPolicy struct {
+	// Total number of attempts, 0 or 1 disables retries
+	MaxAttempts int `yaml:"max-attempts"`
+	// Backoff before the first retry, doubles on every next retry up to MaxBackoff
+	Backoff    time.Duration `yaml:"backoff"`
+	MaxBackoff time.Duration `yaml:"max-backoff"`
+	// Methods that are retried, defaults to the idempotent methods
+	Methods []string `yaml:"methods"`
+	// Response status codes that are retried, defaults to 502, 503 and 504. Connection errors are always retried.
+	StatusCodes []int `yaml:"status-codes"`
+}
+
+// ClientProfile configures the HTTP client that sends the mirrored requests. Zero values fall back to the defaults.
+type ClientProfile struct {
+	// Overall timeout of a request, including reading the response (default 20s)
+	Timeout               time.Duration `yaml:"timeout"`
+	DialTimeout           time.Duration `yaml:"dial-timeout"`
+	TLSHandshakeTimeout   time.Duration `yaml:"tls-handshake-timeout"`
+	ResponseHeaderTimeout time.Duration `yaml:"response-header-timeout"`
+	IdleConnTimeout       time.Duration `yaml:"idle-conn-timeout"`
+	KeepAlive             time.Duration `yaml:"keep-alive"`
+	DisableKeepAlives     bool          `yaml:"disable-keep-alives"`
+	MaxIdleConns          int           `yaml:"max-idle-conns"`
+	MaxIdleConnsPerHost   int           `yaml:"max-idle-conns-per-host"`
+	MaxConnsPerHost       int           `yaml:"max-conns-per-host"`
+	// Either 'follow' (default) or 'none'
+	Redirects string `yaml:"redirects"`
+	// Local IP address to send the requests from
+	SourceAddress string `yaml:"source-address"`
+	// Either 'auto' (default, negotiated via TLS), 'off' or 'prior-knowledge' (also for cleartext HTTP/2)
+	HTTP2 string `yaml:"http2"`
+	// Upstream proxy for the mirrored requests: http://[user:password@]host:port or socks5://[user:password@]host:port
+	Proxy string `yaml:"proxy"`
+	// Use the proxy from the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables when no proxy is set
+	ProxyFromEnvironment bool `yaml:"proxy-from-environment"`
+}
+
+// DNSSettings control how the host of a target is resolved.
+type DNSSettings struct {
+	// Static overrides in curl's --resolve format: host:port:address
+	Resolve []string `yaml:"resolve"`
+	// Re-resolve the host on this interval and send to the returned addresses, 0 disables re-resolution
+	ReResolve time.Duration `yaml:"re-resolve"`
+	// How requests are spread over the resolved addresses: 'balance' (default) or 'fanout'
+	Mode string `yaml:"mode"`
+}
+
+// SourceLabel marks where a target came from, it is set on targets that were found via service discovery.
+const SourceLabel = "source"
+
+// Key identifies the target, this is the name for target groups and the URL otherwise.
+func (t *Target) Key() string {
+	if t.Name != "" {
+		return t.Name
+	}
+
+	return t.URL
+}
@@ -0,0 +1,95 @@
+package discovery
+
+import (
+	"log"
+	"reflect"
+	"time"
+
+	"github.com/rb3ckers/trafficmirror/internal/config"
+	"github.com/rb3ckers/trafficmirror/internal/mirror"
+)
+
+// A Source discovers mirror targets from outside of trafficmirror.
+type Source interface {
+	// Name is used as value of the source label of the discovered targets
+	Name() string
+	Discover() ([]*config.Target, error)
+}
+
+// Run discovers the targets of the source on the interval and reconciles them into the reflector, until the done
+// channel is closed.
+func Run(reflector *mirror.Reflector, source Source, interval time.Duration, doneCh <-chan struct{}) {
+	log.Printf("Discovering targets from %s every %s.", source.Name(), interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		targets, err := source.Discover()
+		if err != nil {
+			// Keep the targets from the previous discovery
+			log.Printf("Failed to discover targets from %s: %v", source.Name(), err)
+		} else {
+			Reconcile(reflector, source.Name(), targets)
+		}
+
+		select {
+		case <-doneCh:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Reconcile makes the targets discovered by the source match the given targets. Only targets that carry the source
+// label of this source are touched, manually added targets are left alone.
+func Reconcile(reflector *mirror.Reflector, source string, targets []*config.Target) {
+	current := reflector.TargetsWithLabel(config.SourceLabel, source)
+	desired := make(map[string]*config.Target, len(targets))
+
+	var added []*config.Target
+
+	// Targets with changed settings are removed and added again
+	var removed []string
+
+	for _, target := range targets {
+		if target.Labels == nil {
+			target.Labels = make(map[string]string)
+		}
+
+		target.Labels[config.SourceLabel] = source
+		key := target.Key()
+		desired[key] = target
+
+		existing, ok := current[key]
+		if !ok && reflector.HasTarget(key) {
+			log.Printf("Not adding discovered target '%s', it was already added from another source.", key)
+			continue
+		}
+
+		switch {
+		case !ok:
+			added = append(added, target)
+		case !reflect.DeepEqual(existing, target):
+			added = append(added, target)
+			removed = append(removed, key)
+		}
+	}
+
+	for key := range current {
+		if _, ok := desired[key]; !ok {
+			removed = append(removed, key)
+		}
+	}
+
+	if len(removed) > 0 {
+		reflector.RemoveMirrors(removed)
+	}
+
+	// Add them one by one, so one invalid target does not block the others
+	for _, target := range added {
+		if err := reflector.AddTargets([]*config.Target{target}); err != nil {
+			log.Printf("Failed to add discovered target: %v", err)
+		}
+	}
+}
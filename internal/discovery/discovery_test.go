@@ -0,0 +1,69 @@
+package discovery
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/rb3ckers/trafficmirror/internal/config"
+	"github.com/rb3ckers/trafficmirror/internal/mirror"
+	"github.com/stretchr/testify/assert"
+)
+
+func listURLs(reflector *mirror.Reflector) []string {
+	var urls []string
+
+	for _, status := range reflector.ListMirrors() {
+		if status.URL != "internal-reflector" {
+			urls = append(urls, status.URL)
+		}
+	}
+
+	sort.Strings(urls)
+
+	return urls
+}
+
+func TestFileSource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "targets.json")
+	assert.NoError(t, os.WriteFile(path, []byte(`[
+		{"targets": ["shadow-1:8080", "http://shadow-2:8080"], "labels": {"team": "payments"}},
+		{"targets": ["shadow-3:8443"], "labels": {"__scheme__": "https"}}
+	]`), 0o600))
+
+	targets, err := (&FileSource{Path: path}).Discover()
+	assert.NoError(t, err)
+	assert.Len(t, targets, 3)
+	assert.Equal(t, "http://shadow-1:8080", targets[0].URL)
+	assert.Equal(t, map[string]string{"team": "payments"}, targets[0].Labels)
+	assert.Equal(t, "http://shadow-2:8080", targets[1].URL)
+	assert.Equal(t, "https://shadow-3:8443", targets[2].URL)
+	assert.Empty(t, targets[2].Labels)
+}
+
+func TestSRVSource(t *testing.T) {
+	source := NewSRVSource("_http._tcp.shadow.example.com", "http")
+	source.lookup = func(service, proto, name string) (string, []*net.SRV, error) {
+		return "", []*net.SRV{{Target: "shadow-1.example.com.", Port: 8080}}, nil
+	}
+
+	targets, err := source.Discover()
+	assert.NoError(t, err)
+	assert.Len(t, targets, 1)
+	assert.Equal(t, "http://shadow-1.example.com:8080", targets[0].URL)
+}
+
+func TestReconcileOnlyTouchesDiscoveredTargets(t *testing.T) {
+	reflector := mirror.NewReflector(config.Default())
+	assert.NoError(t, reflector.AddMirrors([]string{"http://manual:8080"}, false))
+
+	Reconcile(reflector, "file:targets.json", []*config.Target{{URL: "http://shadow-1:8080"}, {URL: "http://shadow-2:8080"}})
+	assert.Equal(t, []string{"http://manual:8080", "http://shadow-1:8080", "http://shadow-2:8080"}, listURLs(reflector))
+	assert.Len(t, reflector.TargetsWithLabel(config.SourceLabel, "file:targets.json"), 2)
+
+	Reconcile(reflector, "file:targets.json", []*config.Target{{URL: "http://shadow-2:8080"}, {URL: "http://manual:8080"}})
+	assert.Equal(t, []string{"http://manual:8080", "http://shadow-2:8080"}, listURLs(reflector))
+	assert.Len(t, reflector.TargetsWithLabel(config.SourceLabel, "file:targets.json"), 1)
+}
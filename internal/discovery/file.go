@@ -0,0 +1,73 @@
+package discovery
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/rb3ckers/trafficmirror/internal/config"
+	"gopkg.in/yaml.v3"
+)
+
+// Label that sets the scheme of the targets in a file, like Prometheus' __scheme__ relabeling
+const schemeLabel = "__scheme__"
+
+// FileSource reads targets from a JSON or YAML file in the Prometheus file_sd format:
+//
+//	[{"targets": ["shadow-1:8080", "http://shadow-2:8080"], "labels": {"team": "payments"}}]
+type FileSource struct {
+	Path string
+}
+
+type fileGroup struct {
+	Targets []string          `yaml:"targets"`
+	Labels  map[string]string `yaml:"labels"`
+}
+
+func (f *FileSource) Name() string {
+	return "file:" + f.Path
+}
+
+func (f *FileSource) Discover() ([]*config.Target, error) {
+	data, err := os.ReadFile(f.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	// YAML is a superset of JSON, so this reads both
+	var groups []fileGroup
+	if err := yaml.Unmarshal(data, &groups); err != nil {
+		return nil, fmt.Errorf("failed to parse '%s': %w", f.Path, err)
+	}
+
+	var targets []*config.Target
+
+	for _, group := range groups {
+		scheme := "http"
+		labels := make(map[string]string, len(group.Labels))
+
+		for k, v := range group.Labels {
+			if k == schemeLabel {
+				scheme = v
+			} else {
+				labels[k] = v
+			}
+		}
+
+		for _, address := range group.Targets {
+			targetURL := address
+			if !strings.Contains(address, "://") {
+				targetURL = scheme + "://" + address
+			}
+
+			targetLabels := make(map[string]string, len(labels))
+			for k, v := range labels {
+				targetLabels[k] = v
+			}
+
+			targets = append(targets, &config.Target{URL: targetURL, Labels: targetLabels})
+		}
+	}
+
+	return targets, nil
+}
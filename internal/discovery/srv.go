@@ -0,0 +1,48 @@
+package discovery
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/rb3ckers/trafficmirror/internal/config"
+)
+
+// SRVSource looks up targets from a DNS SRV record, e.g. '_http._tcp.shadow.example.com'.
+type SRVSource struct {
+	Record string
+	Scheme string
+
+	lookup func(service, proto, name string) (string, []*net.SRV, error)
+}
+
+func NewSRVSource(record, scheme string) *SRVSource {
+	return &SRVSource{
+		Record: record,
+		Scheme: scheme,
+		lookup: net.LookupSRV,
+	}
+}
+
+func (s *SRVSource) Name() string {
+	return "dns-srv:" + s.Record
+}
+
+func (s *SRVSource) Discover() ([]*config.Target, error) {
+	_, records, err := s.lookup("", "", s.Record)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up '%s': %w", s.Record, err)
+	}
+
+	targets := make([]*config.Target, 0, len(records))
+
+	for _, record := range records {
+		host := strings.TrimSuffix(record.Target, ".")
+		address := net.JoinHostPort(host, strconv.Itoa(int(record.Port)))
+
+		targets = append(targets, &config.Target{URL: s.Scheme + "://" + address})
+	}
+
+	return targets, nil
+}
@@ -0,0 +1,103 @@
+package mirror
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/rb3ckers/trafficmirror/internal/config"
+)
+
+const (
+	AmplifySequential = "sequential"
+	AmplifyConcurrent = "concurrent"
+)
+
+const defaultCopyHeader = "X-Mirror-Copy"
+
+// More copies per request would turn the traffic mirror into a load generator
+const maxCopies = 100
+
+// Sends every request a number of times, marking each copy with its index
+type amplifier struct {
+	copies     int
+	concurrent bool
+	header     string
+}
+
+func newAmplifier(cfg config.Amplification) (*amplifier, error) {
+	if cfg.Copies < 0 {
+		return nil, fmt.Errorf("the number of copies can not be negative")
+	}
+
+	if cfg.Copies > maxCopies {
+		return nil, fmt.Errorf("the number of copies can not be more than %d", maxCopies)
+	}
+
+	a := &amplifier{copies: cfg.Copies, header: cfg.Header}
+
+	switch strings.ToLower(cfg.Mode) {
+	case "", AmplifySequential:
+	case AmplifyConcurrent:
+		a.concurrent = true
+	default:
+		return nil, fmt.Errorf("invalid amplify mode '%s', expected '%s' or '%s'", cfg.Mode, AmplifySequential, AmplifyConcurrent)
+	}
+
+	if a.copies <= 1 {
+		return nil, nil
+	}
+
+	if a.header == "" {
+		a.header = defaultCopyHeader
+	}
+
+	return a, nil
+}
+
+// Sends the copies of the request to the endpoint. The result is the first error, or else the highest status code,
+// so a single failing copy counts as a failure.
+func (m *Mirror) sendCopies(req *Request, ep endpoint) (int, error) {
+	if m.amplifier == nil {
+		return m.sendWithRetries(req, ep)
+	}
+
+	statusCodes := make([]int, m.amplifier.copies)
+	errs := make([]error, m.amplifier.copies)
+
+	var wg sync.WaitGroup
+
+	for i := range statusCodes {
+		copyEndpoint := ep
+		copyEndpoint.copy = i + 1
+
+		if !m.amplifier.concurrent {
+			statusCodes[i], errs[i] = m.sendWithRetries(req, copyEndpoint)
+			continue
+		}
+
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			statusCodes[i], errs[i] = m.sendWithRetries(req, copyEndpoint)
+		}(i)
+	}
+
+	wg.Wait()
+
+	var result int
+
+	for i, err := range errs {
+		if err != nil {
+			return statusCodes[i], err
+		}
+
+		if statusCodes[i] > result {
+			result = statusCodes[i]
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,59 @@
+package mirror
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rb3ckers/trafficmirror/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAmplifiedRequestsCarryCopyIndex(t *testing.T) {
+	for _, mode := range []string{AmplifySequential, AmplifyConcurrent} {
+		var (
+			lock   sync.Mutex
+			copies []string
+		)
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			lock.Lock()
+			defer lock.Unlock()
+
+			copies = append(copies, r.Header.Get("X-Load-Copy"))
+		}))
+
+		r := NewReflector(config.Default())
+		assert.NoError(t, r.AddTargets([]*config.Target{{URL: server.URL, Amplify: config.Amplification{Copies: 3, Mode: mode, Header: "X-Load-Copy"}}}))
+
+		req := mkGetRequest(1)
+		r.sendToMirrors(req)
+
+		assert.Eventually(t, func() bool { return r.ListMirrors()[0].Epoch == 1 }, time.Second, 10*time.Millisecond)
+
+		lock.Lock()
+		sort.Strings(copies)
+		assert.Equal(t, []string{"1", "2", "3"}, copies)
+		lock.Unlock()
+
+		// The original request is not modified
+		assert.Empty(t, req.originalRequest.Header.Get("X-Load-Copy"))
+
+		server.Close()
+	}
+}
+
+func TestNotAmplified(t *testing.T) {
+	a, err := newAmplifier(config.Amplification{Copies: 1})
+	assert.NoError(t, err)
+	assert.Nil(t, a)
+
+	_, err = newAmplifier(config.Amplification{Copies: 2, Mode: "parallel"})
+	assert.Error(t, err)
+
+	_, err = newAmplifier(config.Amplification{Copies: maxCopies + 1})
+	assert.Error(t, err)
+}
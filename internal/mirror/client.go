@@ -0,0 +1,168 @@
+package mirror
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/rb3ckers/trafficmirror/internal/config"
+	"golang.org/x/net/http2"
+)
+
+const (
+	defaultClientTimeout       = 20 * time.Second
+	defaultDialTimeout         = 30 * time.Second
+	defaultKeepAlive           = 30 * time.Second
+	defaultTLSHandshakeTimeout = 10 * time.Second
+	defaultIdleConnTimeout     = 90 * time.Second
+	defaultMaxIdleConns        = 100
+
+	RedirectsFollow = "follow"
+	RedirectsNone   = "none"
+
+	HTTP2Auto           = "auto"
+	HTTP2Off            = "off"
+	HTTP2PriorKnowledge = "prior-knowledge"
+)
+
+// Builds the HTTP client for sending mirrored requests according to the client profile of the target.
+// The scheme of the target is only needed to know whether HTTP/2 with prior knowledge runs over TLS.
+// Overrides map a 'host:port' to the 'address:port' that is dialed instead.
+func newHTTPClient(profile config.ClientProfile, scheme string, overrides map[string]string) (*http.Client, error) {
+	dialer := &net.Dialer{
+		Timeout:   orDefault(profile.DialTimeout, defaultDialTimeout),
+		KeepAlive: orDefault(profile.KeepAlive, defaultKeepAlive),
+	}
+
+	if profile.SourceAddress != "" {
+		ip := net.ParseIP(profile.SourceAddress)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid source address '%s'", profile.SourceAddress)
+		}
+
+		dialer.LocalAddr = &net.TCPAddr{IP: ip}
+	}
+
+	dial := dialer.DialContext
+	if len(overrides) > 0 {
+		dial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if override, ok := overrides[addr]; ok {
+				addr = override
+			}
+
+			return dialer.DialContext(ctx, network, addr)
+		}
+	}
+
+	proxy, err := proxyFunc(profile)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{
+		Timeout: orDefault(profile.Timeout, defaultClientTimeout),
+	}
+
+	switch strings.ToLower(profile.Redirects) {
+	case "", RedirectsFollow:
+		// Default behavior of the http.Client
+	case RedirectsNone:
+		client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+	default:
+		return nil, fmt.Errorf("invalid redirect policy '%s', expected '%s' or '%s'", profile.Redirects, RedirectsFollow, RedirectsNone)
+	}
+
+	switch strings.ToLower(profile.HTTP2) {
+	case "", HTTP2Auto, HTTP2Off:
+		transport := &http.Transport{
+			Proxy:                 proxy,
+			DialContext:           dial,
+			ForceAttemptHTTP2:     true,
+			TLSHandshakeTimeout:   orDefault(profile.TLSHandshakeTimeout, defaultTLSHandshakeTimeout),
+			ResponseHeaderTimeout: profile.ResponseHeaderTimeout,
+			IdleConnTimeout:       orDefault(profile.IdleConnTimeout, defaultIdleConnTimeout),
+			DisableKeepAlives:     profile.DisableKeepAlives,
+			MaxIdleConns:          defaultMaxIdleConns,
+			MaxIdleConnsPerHost:   profile.MaxIdleConnsPerHost,
+			MaxConnsPerHost:       profile.MaxConnsPerHost,
+		}
+
+		if profile.MaxIdleConns > 0 {
+			transport.MaxIdleConns = profile.MaxIdleConns
+		}
+
+		if strings.ToLower(profile.HTTP2) == HTTP2Off {
+			// A non-nil empty map disables HTTP/2
+			transport.ForceAttemptHTTP2 = false
+			transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+		}
+
+		client.Transport = transport
+	case HTTP2PriorKnowledge:
+		if proxy != nil {
+			return nil, fmt.Errorf("http2 '%s' can not be used with an upstream proxy", HTTP2PriorKnowledge)
+		}
+
+		client.Transport = &http2.Transport{
+			// Allow cleartext HTTP/2 (h2c) for http targets
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, cfg *tls.Config) (net.Conn, error) {
+				conn, err := dial(ctx, network, addr)
+				if err != nil || scheme != "https" {
+					return conn, err
+				}
+
+				tlsConn := tls.Client(conn, cfg)
+				if err := tlsConn.HandshakeContext(ctx); err != nil {
+					conn.Close()
+					return nil, err
+				}
+
+				return tlsConn, nil
+			},
+			ReadIdleTimeout: orDefault(profile.KeepAlive, defaultKeepAlive),
+		}
+	default:
+		return nil, fmt.Errorf("invalid http2 setting '%s', expected '%s', '%s' or '%s'", profile.HTTP2, HTTP2Auto, HTTP2Off, HTTP2PriorKnowledge)
+	}
+
+	return client, nil
+}
+
+func proxyFunc(profile config.ClientProfile) (func(*http.Request) (*url.URL, error), error) {
+	if profile.Proxy == "" {
+		if profile.ProxyFromEnvironment {
+			return http.ProxyFromEnvironment, nil
+		}
+
+		return nil, nil
+	}
+
+	proxyURL, err := url.Parse(profile.Proxy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy '%s': %w", profile.Proxy, err)
+	}
+
+	switch proxyURL.Scheme {
+	case "http", "https", "socks5", "socks5h":
+		// The transport handles CONNECT, SOCKS5 and the authentication from the user info
+		return http.ProxyURL(proxyURL), nil
+	default:
+		return nil, fmt.Errorf("invalid proxy '%s', expected a http, https or socks5 proxy", profile.Proxy)
+	}
+}
+
+func orDefault(value, defaultValue time.Duration) time.Duration {
+	if value == 0 {
+		return defaultValue
+	}
+
+	return value
+}
@@ -0,0 +1,243 @@
+package mirror
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/rb3ckers/trafficmirror/internal/config"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+func TestClientDoesNotFollowRedirects(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/elsewhere", http.StatusFound)
+	}))
+	defer server.Close()
+
+	client, err := newHTTPClient(config.ClientProfile{Redirects: RedirectsNone}, "http", nil)
+	assert.NoError(t, err)
+
+	resp, err := client.Get(server.URL) //nolint:noctx
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusFound, resp.StatusCode)
+}
+
+func TestClientHTTP2PriorKnowledge(t *testing.T) {
+	server := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Proto", r.Proto)
+	}), &http2.Server{}))
+	defer server.Close()
+
+	client, err := newHTTPClient(config.ClientProfile{HTTP2: HTTP2PriorKnowledge}, "http", nil)
+	assert.NoError(t, err)
+
+	resp, err := client.Get(server.URL) //nolint:noctx
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "HTTP/2.0", resp.Header.Get("X-Proto"))
+}
+
+func TestClientRejectsInvalidProfile(t *testing.T) {
+	_, err := newHTTPClient(config.ClientProfile{SourceAddress: "not-an-ip"}, "http", nil)
+	assert.Error(t, err)
+
+	_, err = newHTTPClient(config.ClientProfile{Redirects: "sometimes"}, "http", nil)
+	assert.Error(t, err)
+}
+
+func TestClientSendsViaProxy(t *testing.T) {
+	var proxiedURL, proxyAuth string
+
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxiedURL = r.URL.String()
+		proxyAuth = r.Header.Get("Proxy-Authorization")
+	}))
+	defer proxy.Close()
+
+	proxyURL, _ := url.Parse(proxy.URL)
+	proxyURL.User = url.UserPassword("user", "secret")
+
+	client, err := newHTTPClient(config.ClientProfile{Proxy: proxyURL.String()}, "http", nil)
+	assert.NoError(t, err)
+
+	resp, err := client.Get("http://shadow.internal:8080/path") //nolint:noctx
+	assert.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Equal(t, "http://shadow.internal:8080/path", proxiedURL)
+	assert.Equal(t, "Basic dXNlcjpzZWNyZXQ=", proxyAuth)
+}
+
+// A stand-in for an HTTP proxy that tunnels with CONNECT
+func mkConnectProxy(t *testing.T, connected chan<- string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		connected <- r.Host + " " + r.Header.Get("Proxy-Authorization")
+
+		upstream, err := net.Dial("tcp", r.Host)
+		if !assert.NoError(t, err) {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		defer upstream.Close()
+
+		conn, _, err := http.NewResponseController(w).Hijack()
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer conn.Close()
+
+		conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n")) //nolint:errcheck
+
+		go io.Copy(upstream, conn) //nolint:errcheck
+		io.Copy(conn, upstream)    //nolint:errcheck
+	}))
+}
+
+func TestClientTunnelsViaConnectProxy(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Path", r.URL.Path)
+	}))
+	defer server.Close()
+
+	connected := make(chan string, 1)
+
+	proxy := mkConnectProxy(t, connected)
+	defer proxy.Close()
+
+	proxyURL, _ := url.Parse(proxy.URL)
+	proxyURL.User = url.UserPassword("user", "secret")
+
+	client, err := newHTTPClient(config.ClientProfile{Proxy: proxyURL.String()}, "https", nil)
+	assert.NoError(t, err)
+
+	// Trust the certificate of the test server
+	client.Transport.(*http.Transport).TLSClientConfig = server.Client().Transport.(*http.Transport).TLSClientConfig
+
+	resp, err := client.Get(server.URL + "/path") //nolint:noctx
+	assert.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Equal(t, "/path", resp.Header.Get("X-Path"))
+	assert.Equal(t, strings.TrimPrefix(server.URL, "https://")+" Basic dXNlcjpzZWNyZXQ=", <-connected)
+}
+
+// A stand-in for a SOCKS5 proxy with username and password authentication, that dials the address instead of the
+// requested host
+func mkSOCKS5Proxy(t *testing.T, address string, requested chan<- string) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go serveSOCKS5(conn, address, requested)
+		}
+	}()
+
+	return listener
+}
+
+func serveSOCKS5(conn net.Conn, address string, requested chan<- string) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	read := func(n int) []byte {
+		buf := make([]byte, n)
+		if _, err := io.ReadFull(reader, buf); err != nil {
+			return nil
+		}
+
+		return buf
+	}
+	readField := func() string {
+		length := read(1)
+		if length == nil {
+			return ""
+		}
+
+		return string(read(int(length[0])))
+	}
+
+	// Greeting: version and the offered methods, username and password (2) is chosen
+	greeting := read(2)
+	if greeting == nil || greeting[0] != 5 {
+		return
+	}
+
+	read(int(greeting[1]))
+	conn.Write([]byte{5, 2}) //nolint:errcheck
+
+	// Username and password
+	read(1)
+	user := readField()
+	password := readField()
+	conn.Write([]byte{1, 0}) //nolint:errcheck
+
+	// Connect request with a domain name (3)
+	request := read(4)
+	if request == nil || request[1] != 1 || request[3] != 3 {
+		return
+	}
+
+	host := readField()
+	port := read(2)
+	if port == nil {
+		return
+	}
+
+	requested <- fmt.Sprintf("%s:%d %s:%s", host, int(port[0])<<8|int(port[1]), user, password)
+
+	upstream, err := net.Dial("tcp", address)
+	if err != nil {
+		conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0}) //nolint:errcheck
+		return
+	}
+	defer upstream.Close()
+
+	conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0}) //nolint:errcheck
+
+	go io.Copy(upstream, reader) //nolint:errcheck
+	io.Copy(conn, upstream)      //nolint:errcheck
+}
+
+func TestClientSendsViaSOCKS5Proxy(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Host", r.Host)
+	}))
+	defer server.Close()
+
+	requested := make(chan string, 1)
+
+	listener := mkSOCKS5Proxy(t, strings.TrimPrefix(server.URL, "http://"), requested)
+	defer listener.Close()
+
+	client, err := newHTTPClient(config.ClientProfile{Proxy: "socks5://user:secret@" + listener.Addr().String()}, "http", nil)
+	assert.NoError(t, err)
+
+	resp, err := client.Get("http://shadow.internal:8080/path") //nolint:noctx
+	assert.NoError(t, err)
+	resp.Body.Close()
+
+	// The proxy resolves the host of the target
+	assert.Equal(t, "shadow.internal:8080 user:secret", <-requested)
+	assert.Equal(t, "shadow.internal:8080", resp.Header.Get("X-Host"))
+}
@@ -0,0 +1,131 @@
+package mirror
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rb3ckers/trafficmirror/internal/config"
+)
+
+const (
+	defaultCookieIdleTimeout = 30 * time.Minute
+	defaultCookieMaxEntries  = 10000
+)
+
+// Maps production cookie values to the values the target issued in their place, so sessions started on the target
+// keep working for the requests that follow.
+type cookieJar struct {
+	names    map[string]bool
+	mappings *mappingTable
+}
+
+func newCookieJar(cfg config.CookieJar) *cookieJar {
+	if !cfg.Enabled {
+		return nil
+	}
+
+	idleTimeout := cfg.IdleTimeout
+	if idleTimeout <= 0 {
+		idleTimeout = defaultCookieIdleTimeout
+	}
+
+	maxEntries := cfg.MaxEntries
+	if maxEntries <= 0 {
+		maxEntries = defaultCookieMaxEntries
+	}
+
+	j := &cookieJar{mappings: newMappingTable(idleTimeout, maxEntries)}
+
+	if len(cfg.Cookies) > 0 {
+		j.names = make(map[string]bool, len(cfg.Cookies))
+		for _, name := range cfg.Cookies {
+			j.names[name] = true
+		}
+	}
+
+	return j
+}
+
+func (j *cookieJar) tracks(name string) bool {
+	return j.names == nil || j.names[name]
+}
+
+func cookieKey(name, value string) string {
+	return name + "=" + value
+}
+
+// Replaces the production cookies in the header by the mapped values of the target. Cookies without a mapping are
+// passed on as they are.
+func (j *cookieJar) rewrite(header http.Header) {
+	if header.Get("Cookie") == "" {
+		return
+	}
+
+	cookies := (&http.Request{Header: header}).Cookies()
+	rewritten := false
+
+	for _, cookie := range cookies {
+		if !j.tracks(cookie.Name) {
+			continue
+		}
+
+		if value, ok := j.mappings.get(cookieKey(cookie.Name, cookie.Value)); ok {
+			cookie.Value = value
+			rewritten = true
+		}
+	}
+
+	if !rewritten {
+		return
+	}
+
+	pairs := make([]string, len(cookies))
+	for i, cookie := range cookies {
+		pairs[i] = cookie.Name + "=" + cookie.Value
+	}
+
+	header.Set("Cookie", strings.Join(pairs, "; "))
+}
+
+// Maps the cookies the target set in its response to the production cookies of the request: the ones the main
+// target set in its response, or else the ones the request carried.
+func (j *cookieJar) record(req *Request, response *http.Response) {
+	mirrorCookies := response.Cookies()
+	if len(mirrorCookies) == 0 {
+		return
+	}
+
+	production := make(map[string]string)
+
+	for _, cookie := range req.originalRequest.Cookies() {
+		production[cookie.Name] = cookie.Value
+	}
+
+	if req.main != nil && len(req.main.SetCookies) > 0 {
+		mainResponse := &http.Response{Header: http.Header{"Set-Cookie": req.main.SetCookies}}
+		for _, cookie := range mainResponse.Cookies() {
+			production[cookie.Name] = cookie.Value
+		}
+	}
+
+	now := j.mappings.now()
+
+	for _, cookie := range mirrorCookies {
+		value, ok := production[cookie.Name]
+		if !ok || !j.tracks(cookie.Name) {
+			// Nothing in production to map it to
+			continue
+		}
+
+		key := cookieKey(cookie.Name, value)
+
+		if cookie.MaxAge < 0 || (!cookie.Expires.IsZero() && cookie.Expires.Before(now)) {
+			// The target deleted its cookie
+			j.mappings.delete(key)
+			continue
+		}
+
+		j.mappings.set(key, cookie.Value)
+	}
+}
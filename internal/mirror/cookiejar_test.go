@@ -0,0 +1,116 @@
+package mirror
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rb3ckers/trafficmirror/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func mkCookieRequest(cookie string, setCookies ...string) *Request {
+	req := mkGetRequest(1)
+	if cookie != "" {
+		req.originalRequest.Header.Set("Cookie", cookie)
+	}
+
+	req.main = &MainResponse{StatusCode: http.StatusOK, SetCookies: setCookies}
+
+	return req
+}
+
+func mkCookieResponse(setCookies ...string) *http.Response {
+	return &http.Response{Header: http.Header{"Set-Cookie": setCookies}}
+}
+
+func TestCookieJarMapsMainResponseCookies(t *testing.T) {
+	j := newCookieJar(config.CookieJar{Enabled: true})
+
+	j.record(mkCookieRequest("", "session=prod1; Path=/; HttpOnly"), mkCookieResponse("session=shadow1; Path=/"))
+
+	header := http.Header{"Cookie": {"session=prod1; theme=dark"}}
+	j.rewrite(header)
+	assert.Equal(t, "session=shadow1; theme=dark", header.Get("Cookie"))
+
+	// Other sessions are not mapped
+	header = http.Header{"Cookie": {"session=prod2"}}
+	j.rewrite(header)
+	assert.Equal(t, "session=prod2", header.Get("Cookie"))
+}
+
+func TestCookieJarMapsRequestCookies(t *testing.T) {
+	j := newCookieJar(config.CookieJar{Enabled: true, Cookies: []string{"session"}})
+
+	// The target rotated its session, production did not
+	j.record(mkCookieRequest("session=prod1; theme=dark"), mkCookieResponse("session=shadow2", "theme=light"))
+	assert.Equal(t, 1, j.mappings.len())
+
+	header := http.Header{"Cookie": {"session=prod1; theme=dark"}}
+	j.rewrite(header)
+	assert.Equal(t, "session=shadow2; theme=dark", header.Get("Cookie"))
+
+	// The target logs out
+	j.record(mkCookieRequest("session=prod1"), mkCookieResponse("session=; Max-Age=0"))
+	assert.Equal(t, 0, j.mappings.len())
+}
+
+func TestCookieJarForgetsIdleMappings(t *testing.T) {
+	now := time.Now()
+	j := newCookieJar(config.CookieJar{Enabled: true, IdleTimeout: time.Minute, MaxEntries: 2})
+	j.mappings.now = func() time.Time { return now }
+
+	j.record(mkCookieRequest("", "session=prod1"), mkCookieResponse("session=shadow1"))
+	now = now.Add(30 * time.Second)
+	j.record(mkCookieRequest("", "session=prod2"), mkCookieResponse("session=shadow2"))
+
+	// Full, the least recently used mapping is forgotten
+	j.record(mkCookieRequest("", "session=prod3"), mkCookieResponse("session=shadow3"))
+	assert.Equal(t, 2, j.mappings.len())
+
+	header := http.Header{"Cookie": {"session=prod1"}}
+	j.rewrite(header)
+	assert.Equal(t, "session=prod1", header.Get("Cookie"))
+
+	// Idle for too long
+	now = now.Add(2 * time.Minute)
+	header = http.Header{"Cookie": {"session=prod2"}}
+	j.rewrite(header)
+	assert.Equal(t, "session=prod2", header.Get("Cookie"))
+}
+
+func TestCookieJarSessionOnTarget(t *testing.T) {
+	received := make(chan string, 2)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Get("Cookie")
+
+		if r.URL.Path == "/login" {
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "shadow1"})
+		}
+	}))
+	defer server.Close()
+
+	r := NewReflector(config.Default())
+	assert.NoError(t, r.AddTargets([]*config.Target{{URL: server.URL, CookieJar: config.CookieJar{Enabled: true}}}))
+
+	login := mkCookieRequest("", "session=prod1")
+	login.originalRequest = httptest.NewRequest(http.MethodPost, "/login", nil)
+	r.sendToMirrors(login)
+
+	assert.Equal(t, "", <-received)
+
+	next := mkCookieRequest("session=prod1")
+	next.epoch = 2
+	r.sendToMirrors(next)
+
+	select {
+	case cookie := <-received:
+		assert.Equal(t, "session=shadow1", cookie)
+	case <-time.After(time.Second):
+		t.Fatal("request was not mirrored")
+	}
+
+	assert.Equal(t, 1, r.ListMirrors()[0].MappedCookies)
+}
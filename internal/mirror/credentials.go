@@ -0,0 +1,293 @@
+package mirror
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rb3ckers/trafficmirror/internal/config"
+)
+
+const (
+	CredentialsPass   = "pass"
+	CredentialsStrip  = "strip"
+	CredentialsStatic = "static"
+	CredentialsRemint = "remint"
+)
+
+const (
+	AlgorithmHS256 = "HS256"
+	AlgorithmRS256 = "RS256"
+)
+
+// How often a changed secret file is picked up
+const secretFileCheckInterval = 10 * time.Second
+
+// Replaces the production credentials of a request by ones the target trusts
+type credentials struct {
+	header string
+	token  *secretFile
+	minter *jwtMinter
+}
+
+func newCredentials(cfg config.Credentials) (*credentials, error) {
+	c := &credentials{header: cfg.Header}
+	if c.header == "" {
+		c.header = "Authorization"
+	}
+
+	var err error
+
+	switch strings.ToLower(cfg.Mode) {
+	case "", CredentialsPass:
+		return nil, nil
+	case CredentialsStrip:
+		// Only removes the header
+	case CredentialsStatic:
+		if cfg.TokenFile == "" {
+			return nil, fmt.Errorf("static credentials need a token file")
+		}
+
+		if c.token, err = newSecretFile(cfg.TokenFile); err != nil {
+			return nil, err
+		}
+	case CredentialsRemint:
+		if c.minter, err = newJWTMinter(cfg); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("invalid credentials mode '%s', expected '%s', '%s', '%s' or '%s'", cfg.Mode, CredentialsPass, CredentialsStrip, CredentialsStatic, CredentialsRemint)
+	}
+
+	return c, nil
+}
+
+// Sets the credentials for the target in the header, based on the original credentials.
+func (c *credentials) apply(header http.Header, original string) {
+	header.Del(c.header)
+
+	switch {
+	case c.token != nil:
+		token := c.token.value()
+		if token == "" {
+			return
+		}
+
+		if !strings.Contains(token, " ") {
+			token = "Bearer " + token
+		}
+
+		header.Set(c.header, token)
+	case c.minter != nil:
+		// Anything that is not a JWT bearer token is dropped, it would only be meaningful in production
+		if scheme, token, ok := strings.Cut(original, " "); ok && strings.EqualFold(scheme, "Bearer") {
+			if minted, err := c.minter.remint(token); err == nil {
+				header.Set(c.header, "Bearer "+minted)
+			}
+		}
+	}
+}
+
+// A secrets file that is re-read when it changes, so rotated secrets are picked up
+type secretFile struct {
+	sync.Mutex
+	path      string
+	secret    string
+	modTime   time.Time
+	checkedAt time.Time
+}
+
+func newSecretFile(path string) (*secretFile, error) {
+	f := &secretFile{path: path}
+	if err := f.load(); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func (f *secretFile) load() error {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return err
+	}
+
+	f.checkedAt = time.Now()
+
+	if info.ModTime().Equal(f.modTime) {
+		return nil
+	}
+
+	content, err := os.ReadFile(f.path)
+	if err != nil {
+		return err
+	}
+
+	f.secret = strings.TrimSpace(string(content))
+
+	f.modTime = info.ModTime()
+
+	return nil
+}
+
+func (f *secretFile) value() string {
+	f.Lock()
+	defer f.Unlock()
+
+	if time.Since(f.checkedAt) > secretFileCheckInterval {
+		// Keep using the last secret when the file is temporarily unavailable
+		f.load() //nolint:errcheck
+	}
+
+	return f.secret
+}
+
+// Re-signs JWTs with a local key, keeping their claims
+type jwtMinter struct {
+	algorithm string
+	secret    []byte
+	key       *rsa.PrivateKey
+	keyID     string
+	ttl       time.Duration
+	now       func() time.Time
+}
+
+func newJWTMinter(cfg config.Credentials) (*jwtMinter, error) {
+	if cfg.KeyFile == "" {
+		return nil, fmt.Errorf("re-minting credentials needs a key file")
+	}
+
+	content, err := os.ReadFile(cfg.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &jwtMinter{algorithm: strings.ToUpper(cfg.Algorithm), keyID: cfg.KeyID, ttl: cfg.TTL, now: time.Now}
+
+	switch m.algorithm {
+	case "", AlgorithmHS256:
+		m.algorithm = AlgorithmHS256
+		m.secret = bytes.TrimSpace(content)
+	case AlgorithmRS256:
+		if m.key, err = parseRSAKey(content); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("invalid algorithm '%s', expected '%s' or '%s'", cfg.Algorithm, AlgorithmHS256, AlgorithmRS256)
+	}
+
+	return m, nil
+}
+
+func parseRSAKey(content []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, fmt.Errorf("key file does not contain a PEM encoded key")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("key file does not contain an RSA private key")
+	}
+
+	return key, nil
+}
+
+// Returns the token with a new header and signature. The original signature is not verified, the token comes from
+// production traffic.
+func (m *jwtMinter) remint(token string) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 { //nolint:gomnd
+		return "", fmt.Errorf("not a JWT")
+	}
+
+	payload := parts[1]
+
+	if m.ttl > 0 {
+		var err error
+		if payload, err = m.refreshExpiry(payload); err != nil {
+			return "", err
+		}
+	}
+
+	header := map[string]string{"alg": m.algorithm, "typ": "JWT"}
+	if m.keyID != "" {
+		header["kid"] = m.keyID
+	}
+
+	encodedHeader, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(encodedHeader) + "." + payload
+
+	signature, err := m.sign([]byte(signingInput))
+	if err != nil {
+		return "", err
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// Sets the issued at and expiry claims, so the token is valid for the TTL from now.
+func (m *jwtMinter) refreshExpiry(payload string) (string, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return "", err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(decoded))
+	decoder.UseNumber()
+
+	var claims map[string]interface{}
+	if err := decoder.Decode(&claims); err != nil {
+		return "", err
+	}
+
+	now := m.now()
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(m.ttl).Unix()
+
+	encoded, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(encoded), nil
+}
+
+func (m *jwtMinter) sign(input []byte) ([]byte, error) {
+	if m.key == nil {
+		mac := hmac.New(sha256.New, m.secret)
+		mac.Write(input)
+
+		return mac.Sum(nil), nil
+	}
+
+	digest := sha256.Sum256(input)
+
+	return rsa.SignPKCS1v15(rand.Reader, m.key, crypto.SHA256, digest[:])
+}
@@ -0,0 +1,405 @@
+package mirror
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rb3ckers/trafficmirror/internal/config"
+)
+
+const defaultDelayDir = "trafficmirror-delay"
+
+// The file in the directory of a delayed target that holds the key of the target
+const delayKeyFile = "target"
+
+// How often a paused delayed target checks whether it was resumed
+const delayPausePoll = time.Second
+
+const defaultDelayMaxBacklog = 10000
+
+// Requests waiting to be written to the backlog, more are dropped so the reflector never waits for the disk
+const delayWriteBuffer = 1000
+
+// A request as it is stored in the backlog of a delayed target
+type delayedRequest struct {
+	Received   time.Time   `json:"received"`
+	Method     string      `json:"method"`
+	RequestURI string      `json:"uri"`
+	Host       string      `json:"host"`
+	RemoteAddr string      `json:"remote-addr"`
+	Header     http.Header `json:"header"`
+	Body       []byte      `json:"body"`
+	// Kept for the main response headers
+	Main *MainResponse `json:"main,omitempty"`
+}
+
+// The backlog of a delayed target, one file per request in a directory per target. The file names sort in order
+// of arrival, so the backlog survives restarts.
+type delayQueue struct {
+	sync.Mutex
+	dir         string
+	delay       time.Duration
+	maxBacklog  int
+	concurrency int
+	files       []string
+	// Requests taken from the files that are being sent
+	sending   int
+	seq       uint64
+	notifyCh  chan struct{}
+	pendingCh chan pendingDelay
+}
+
+// A request that is not yet written to the backlog
+type pendingDelay struct {
+	req      *Request
+	received time.Time
+}
+
+func delayDir(cfg *config.Config) string {
+	if cfg.DelayDir != "" {
+		return cfg.DelayDir
+	}
+
+	return filepath.Join(os.TempDir(), defaultDelayDir)
+}
+
+func newDelayQueue(baseDir, key string, target *config.Target) (*delayQueue, error) {
+	if target.Delay <= 0 {
+		return nil, nil
+	}
+
+	// Keys are URLs, which are not valid file names on every platform
+	hash := sha256.Sum256([]byte(key))
+
+	dir := filepath.Join(baseDir, hex.EncodeToString(hash[:]))
+	if err := os.MkdirAll(dir, 0o700); err != nil { //nolint:gomnd
+		return nil, fmt.Errorf("can not create delay directory: %w", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, delayKeyFile), []byte(key), 0o600); err != nil { //nolint:gomnd
+		return nil, fmt.Errorf("can not create delay directory: %w", err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	q := &delayQueue{
+		dir:         dir,
+		delay:       target.Delay,
+		maxBacklog:  target.DelayMaxBacklog,
+		concurrency: target.DelayConcurrency,
+		notifyCh:    make(chan struct{}, 1),
+		pendingCh:   make(chan pendingDelay, delayWriteBuffer),
+	}
+
+	if q.maxBacklog <= 0 {
+		q.maxBacklog = defaultDelayMaxBacklog
+	}
+
+	if q.concurrency <= 0 {
+		q.concurrency = 1
+	}
+
+	// Entries are sorted by name, which is the order of arrival
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), ".json") {
+			q.files = append(q.files, entry.Name())
+		}
+	}
+
+	if len(q.files) > 0 {
+		log.Printf("Found %d delayed requests in %s.", len(q.files), dir)
+	}
+
+	return q, nil
+}
+
+// Hands the request to the writer of the backlog, it is dropped when the backlog is full.
+func (q *delayQueue) enqueue(req *Request, received time.Time, targetURL string) {
+	if q.len() >= q.maxBacklog {
+		log.Printf("Delay backlog for target %s exceeded %d, dropping request", targetURL, q.maxBacklog)
+		return
+	}
+
+	select {
+	case q.pendingCh <- pendingDelay{req: req, received: received}:
+	default:
+		log.Printf("Delay backlog for target %s can not keep up, dropping request", targetURL)
+	}
+}
+
+func (q *delayQueue) push(req *Request, received time.Time) error {
+	data, err := json.Marshal(&delayedRequest{
+		Received:   received,
+		Method:     req.originalRequest.Method,
+		RequestURI: req.originalRequest.RequestURI,
+		Host:       req.originalRequest.Host,
+		RemoteAddr: req.originalRequest.RemoteAddr,
+		Header:     req.originalRequest.Header,
+		Body:       req.body,
+		Main:       req.main,
+	})
+	if err != nil {
+		return err
+	}
+
+	q.Lock()
+	defer q.Unlock()
+
+	q.seq++
+	name := fmt.Sprintf("%020d-%010d.json", received.UnixNano(), q.seq)
+	path := filepath.Join(q.dir, name)
+
+	// Write and rename, so a crash never leaves a partial request behind
+	if err := os.WriteFile(path+".tmp", data, 0o600); err != nil { //nolint:gomnd
+		return err
+	}
+
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+
+	q.files = append(q.files, name)
+
+	select {
+	case q.notifyCh <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+func (q *delayQueue) len() int {
+	q.Lock()
+	defer q.Unlock()
+
+	return len(q.files) + q.sending + len(q.pendingCh)
+}
+
+func (q *delayQueue) oldest() (string, bool) {
+	q.Lock()
+	defer q.Unlock()
+
+	if len(q.files) == 0 {
+		return "", false
+	}
+
+	return q.files[0], true
+}
+
+func (q *delayQueue) load(name string) (*Request, time.Time, error) {
+	data, err := os.ReadFile(filepath.Join(q.dir, name))
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	var delayed delayedRequest
+	if err := json.Unmarshal(data, &delayed); err != nil {
+		return nil, time.Time{}, err
+	}
+
+	requestURL, err := url.ParseRequestURI(delayed.RequestURI)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	originalRequest := &http.Request{
+		Method:     delayed.Method,
+		URL:        requestURL,
+		RequestURI: delayed.RequestURI,
+		Host:       delayed.Host,
+		RemoteAddr: delayed.RemoteAddr,
+		Header:     delayed.Header,
+	}
+
+	return &Request{originalRequest: originalRequest, body: delayed.Body, main: delayed.Main}, delayed.Received, nil
+}
+
+// Takes the oldest request from the backlog to send it, returns false when it is no longer the oldest.
+func (q *delayQueue) take(name string) bool {
+	q.Lock()
+	defer q.Unlock()
+
+	if len(q.files) == 0 || q.files[0] != name {
+		return false
+	}
+
+	q.files = q.files[1:]
+	q.sending++
+
+	return true
+}
+
+// Removes a request that was taken from the backlog
+func (q *delayQueue) done(name string) {
+	q.Lock()
+	defer q.Unlock()
+
+	q.sending--
+
+	if err := os.Remove(filepath.Join(q.dir, name)); err != nil && !os.IsNotExist(err) {
+		log.Printf("Failed to remove delayed request: %v", err)
+	}
+}
+
+// Drops the whole backlog
+func (q *delayQueue) discard() {
+	q.Lock()
+	defer q.Unlock()
+
+	q.files = nil
+	q.dropPending()
+
+	if err := os.RemoveAll(q.dir); err != nil {
+		log.Printf("Failed to remove delay directory: %v", err)
+	}
+
+	if err := os.MkdirAll(q.dir, 0o700); err != nil { //nolint:gomnd
+		log.Printf("Failed to create delay directory: %v", err)
+	}
+}
+
+// Drops the requests that are not yet written
+func (q *delayQueue) dropPending() {
+	for {
+		select {
+		case <-q.pendingCh:
+		default:
+			return
+		}
+	}
+}
+
+// Writes the requests to the backlog, after the changes that are made to every mirrored request.
+func (m *Mirror) writeDelayed() {
+	q := m.delayed
+
+	for {
+		select {
+		case pending := <-q.pendingCh:
+			if err := q.push(m.prepared(pending.req), pending.received); err != nil {
+				log.Printf("Failed to delay request for %s: %v", m.targetURL, err)
+			}
+		case <-m.doneCh:
+			return
+		}
+	}
+}
+
+// Sends the backlog of a delayed mirror in order of arrival, each request once its delay has passed. Up to the
+// concurrency of the backlog due requests are sent at the same time.
+func (m *Mirror) runDelayed() {
+	q := m.delayed
+	slots := make(chan struct{}, q.concurrency)
+
+	for {
+		name, ok := q.oldest()
+		if !ok {
+			select {
+			case <-q.notifyCh:
+				continue
+			case <-m.doneCh:
+				return
+			}
+		}
+
+		req, received, err := q.load(name)
+		if err != nil {
+			log.Printf("Dropping unreadable delayed request %s: %v", name, err)
+
+			if q.take(name) {
+				q.done(name)
+			}
+
+			continue
+		}
+
+		if !m.waitUntil(received.Add(q.delay)) {
+			return
+		}
+
+		select {
+		case slots <- struct{}{}:
+		case <-m.doneCh:
+			return
+		}
+
+		if !q.take(name) {
+			// The backlog was dropped in the meantime
+			<-slots
+			continue
+		}
+
+		go func() {
+			defer func() { <-slots }()
+
+			if !m.isQuarantined() {
+				m.deliver(req)
+			}
+
+			q.done(name)
+		}()
+	}
+}
+
+// Waits until the time has passed and the mirror is not paused, returns false when the mirror was closed.
+func (m *Mirror) waitUntil(due time.Time) bool {
+	select {
+	case <-time.After(time.Until(due)):
+	case <-m.doneCh:
+		return false
+	}
+
+	for m.isPaused() {
+		select {
+		case <-time.After(delayPausePoll):
+		case <-m.doneCh:
+			return false
+		}
+	}
+
+	return true
+}
+
+func (m *Mirror) startDelayed() {
+	if m.delayed != nil {
+		go m.writeDelayed()
+		go m.runDelayed()
+	}
+}
+
+// Drops the backlog, the mirror keeps delaying new requests.
+func (m *Mirror) discardDelayed() {
+	if m.delayed != nil {
+		m.delayed.discard()
+	}
+}
+
+// Removes the backlog and its directory, for when the mirror is removed.
+func (m *Mirror) removeDelayed() {
+	if m.delayed == nil {
+		return
+	}
+
+	m.delayed.Lock()
+	defer m.delayed.Unlock()
+
+	m.delayed.files = nil
+	m.delayed.dropPending()
+
+	if err := os.RemoveAll(m.delayed.dir); err != nil {
+		log.Printf("Failed to remove delay directory: %v", err)
+	}
+}
@@ -0,0 +1,192 @@
+package mirror
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rb3ckers/trafficmirror/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func mkDelayedRequest(uri string) *Request {
+	req := mkRequest(1, []uint64{})
+	req.originalRequest = httptest.NewRequest(http.MethodPost, uri, nil)
+	req.originalRequest.Header.Set("X-Test", "yes")
+	req.body = []byte("body of " + uri)
+
+	return req
+}
+
+func TestDelayedTargetReceivesRequestsLater(t *testing.T) {
+	var (
+		lock     sync.Mutex
+		received []string
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		assert.Equal(t, "yes", r.Header.Get("X-Test"))
+		received = append(received, r.URL.Path)
+	}))
+	defer server.Close()
+
+	cfg := config.Default()
+	cfg.DelayDir = t.TempDir()
+
+	r := NewReflector(cfg)
+	assert.NoError(t, r.AddTargets([]*config.Target{{URL: server.URL, Delay: 200 * time.Millisecond}}))
+
+	for epoch, uri := range []string{"/first", "/second"} {
+		req := mkDelayedRequest(uri)
+		req.epoch = uint64(epoch + 1)
+		r.sendToMirrors(req)
+	}
+
+	status := r.ListMirrors()[0]
+	assert.Equal(t, uint64(2), status.Epoch)
+	assert.Equal(t, 2, status.DelayedRequests)
+
+	time.Sleep(100 * time.Millisecond)
+	lock.Lock()
+	assert.Empty(t, received)
+	lock.Unlock()
+
+	assert.Eventually(t, func() bool { return r.ListMirrors()[0].DelayedRequests == 0 }, time.Second, 10*time.Millisecond)
+
+	lock.Lock()
+	assert.Equal(t, []string{"/first", "/second"}, received)
+	lock.Unlock()
+
+	r.RemoveMirrors([]string{server.URL})
+
+	entries, err := os.ReadDir(cfg.DelayDir)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestDelayQueueSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+
+	q, err := newDelayQueue(dir, "http://shadow:8080", &config.Target{Delay: time.Hour})
+	assert.NoError(t, err)
+
+	received := time.Now()
+	assert.NoError(t, q.push(mkDelayedRequest("/a?b=c"), received))
+	assert.NoError(t, q.push(mkDelayedRequest("/d"), received))
+
+	q, err = newDelayQueue(dir, "http://shadow:8080", &config.Target{Delay: time.Hour})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, q.len())
+
+	name, ok := q.oldest()
+	assert.True(t, ok)
+
+	req, at, err := q.load(name)
+	assert.NoError(t, err)
+	assert.True(t, received.Equal(at))
+	assert.Equal(t, "/a?b=c", req.originalRequest.RequestURI)
+	assert.Equal(t, "c", req.originalRequest.URL.Query().Get("b"))
+	assert.Equal(t, []byte("body of /a?b=c"), req.body)
+
+	assert.True(t, q.take(name))
+	assert.False(t, q.take(name))
+	q.done(name)
+	assert.Equal(t, 1, q.len())
+	_, err = os.Stat(filepath.Join(q.dir, name))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestDelayDirectoryIsNamedAfterHashOfKey(t *testing.T) {
+	dir := t.TempDir()
+
+	q, err := newDelayQueue(dir, "http://shadow:8080", &config.Target{Delay: time.Hour})
+	assert.NoError(t, err)
+
+	// Valid on every platform
+	assert.Regexp(t, `^[0-9a-f]{64}$`, filepath.Base(q.dir))
+
+	key, err := os.ReadFile(filepath.Join(q.dir, delayKeyFile))
+	assert.NoError(t, err)
+	assert.Equal(t, "http://shadow:8080", string(key))
+	assert.Equal(t, 0, q.len())
+}
+
+func TestDelayBacklogLeavesOutTheMainResponseBody(t *testing.T) {
+	dir := t.TempDir()
+
+	q, err := newDelayQueue(dir, "http://shadow:8080", &config.Target{Delay: time.Hour})
+	assert.NoError(t, err)
+
+	req := mkDelayedRequest("/orders")
+	req.main = &MainResponse{StatusCode: http.StatusCreated, Location: "/orders/secret-id", Body: []byte(`{"email":"secret@example.com"}`), SetCookies: []string{"session=secret"}}
+	assert.NoError(t, q.push(req, time.Now()))
+
+	name, _ := q.oldest()
+	data, err := os.ReadFile(filepath.Join(q.dir, name))
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), "secret")
+
+	loaded, _, err := q.load(name)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, loaded.main.StatusCode)
+}
+
+func TestDelayBacklogIsCapped(t *testing.T) {
+	q, err := newDelayQueue(t.TempDir(), "http://shadow:8080", &config.Target{Delay: time.Hour, DelayMaxBacklog: 2})
+	assert.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		q.enqueue(mkDelayedRequest("/a"), time.Now(), "http://shadow:8080")
+	}
+
+	assert.Equal(t, 2, q.len())
+}
+
+func TestDelayedRequestsSentConcurrently(t *testing.T) {
+	var (
+		lock    sync.Mutex
+		active  int
+		maxSeen int
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		active++
+		if active > maxSeen {
+			maxSeen = active
+		}
+		lock.Unlock()
+
+		time.Sleep(50 * time.Millisecond)
+
+		lock.Lock()
+		active--
+		lock.Unlock()
+	}))
+	defer server.Close()
+
+	cfg := config.Default()
+	cfg.DelayDir = t.TempDir()
+
+	r := NewReflector(cfg)
+	assert.NoError(t, r.AddTargets([]*config.Target{{URL: server.URL, Delay: 10 * time.Millisecond, DelayConcurrency: 3}}))
+
+	for epoch := 1; epoch <= 3; epoch++ {
+		req := mkDelayedRequest("/a")
+		req.epoch = uint64(epoch)
+		r.sendToMirrors(req)
+	}
+
+	assert.Eventually(t, func() bool { return r.ListMirrors()[0].DelayedRequests == 0 }, time.Second, 10*time.Millisecond)
+
+	lock.Lock()
+	defer lock.Unlock()
+	assert.Equal(t, 3, maxSeen)
+}
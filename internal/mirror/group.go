@@ -0,0 +1,228 @@
+package mirror
+
+import (
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	BalanceRoundRobin    = "round-robin"
+	BalanceLeastInFlight = "least-in-flight"
+	BalanceHash          = "hash"
+
+	defaultEjectAfter = 3
+)
+
+// A member is one instance of a target group
+type member struct {
+	url      string
+	client   *http.Client
+	inFlight int
+	failures int // Consecutive failures
+	ejected  bool
+}
+
+type MemberStatus struct {
+	URL      string
+	Ejected  bool
+	InFlight int
+}
+
+// A group of instances of which every request is sent to exactly one. Members that fail consecutively are ejected
+// and health checked until they are healthy again.
+type group struct {
+	sync.Mutex
+	members             []*member
+	balance             string
+	hashKey             string
+	ejectAfter          int
+	next                int
+	healthCheckPath     string
+	healthCheckInterval time.Duration
+}
+
+func newGroup(members []*member, balance, hashKey string, ejectAfter int, healthCheckPath string, healthCheckInterval time.Duration) (*group, error) {
+	balance = strings.ToLower(balance)
+	switch balance {
+	case "":
+		balance = BalanceRoundRobin
+	case BalanceRoundRobin, BalanceLeastInFlight, BalanceHash:
+	default:
+		return nil, fmt.Errorf("invalid balance '%s', expected '%s', '%s' or '%s'", balance, BalanceRoundRobin, BalanceLeastInFlight, BalanceHash)
+	}
+
+	if err := validateHashKey(hashKey); err != nil {
+		return nil, err
+	}
+
+	if ejectAfter <= 0 {
+		ejectAfter = defaultEjectAfter
+	}
+
+	// Ejected members are probed at this interval
+	if healthCheckInterval <= 0 {
+		return nil, fmt.Errorf("invalid health check interval %s, it must be positive", healthCheckInterval)
+	}
+
+	return &group{
+		members:             members,
+		balance:             balance,
+		hashKey:             hashKey,
+		ejectAfter:          ejectAfter,
+		healthCheckPath:     healthCheckPath,
+		healthCheckInterval: healthCheckInterval,
+	}, nil
+}
+
+// Picks the member the request is sent to and marks it in flight
+func (g *group) pick(req *Request) (*member, error) {
+	g.Lock()
+	defer g.Unlock()
+
+	healthy := make([]*member, 0, len(g.members))
+
+	for _, m := range g.members {
+		if !m.ejected {
+			healthy = append(healthy, m)
+		}
+	}
+
+	if len(healthy) == 0 {
+		return nil, fmt.Errorf("no healthy members")
+	}
+
+	var picked *member
+
+	switch g.balance {
+	case BalanceLeastInFlight:
+		// Rotate the starting point, so ties are spread over the members
+		g.next++
+
+		for i := range healthy {
+			m := healthy[(g.next+i)%len(healthy)]
+			if picked == nil || m.inFlight < picked.inFlight {
+				picked = m
+			}
+		}
+	case BalanceHash:
+		h := fnv.New32a()
+		h.Write([]byte(hashKeyValue(req, g.hashKey))) //nolint:errcheck
+		picked = healthy[h.Sum32()%uint32(len(healthy))]
+	default:
+		g.next++
+		picked = healthy[g.next%len(healthy)]
+	}
+
+	picked.inFlight++
+
+	return picked, nil
+}
+
+// Records the outcome of a request sent to the member. Returns true when the member got ejected.
+func (g *group) completed(m *member, statusCode int, err error) bool {
+	g.Lock()
+	defer g.Unlock()
+
+	m.inFlight--
+
+	if err == nil && statusCode < http.StatusInternalServerError {
+		m.failures = 0
+		return false
+	}
+
+	m.failures++
+
+	if !m.ejected && m.failures >= g.ejectAfter {
+		log.Printf("Ejecting member %s after %d consecutive failures.", m.url, m.failures)
+		m.ejected = true
+
+		return true
+	}
+
+	return false
+}
+
+// Health checks an ejected member until it is healthy again
+func (g *group) probe(m *member, doneCh <-chan struct{}) {
+	ticker := time.NewTicker(g.healthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-doneCh:
+			return
+		case <-ticker.C:
+			if checkHealth(m.client, m.url+g.healthCheckPath) {
+				g.Lock()
+				m.ejected = false
+				m.failures = 0
+				g.Unlock()
+
+				log.Printf("Member %s is healthy, adding it back to the group.", m.url)
+
+				return
+			}
+		}
+	}
+}
+
+func (g *group) anyHealthy() bool {
+	for _, m := range g.members {
+		if checkHealth(m.client, m.url+g.healthCheckPath) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (g *group) status() []MemberStatus {
+	g.Lock()
+	defer g.Unlock()
+
+	statuses := make([]MemberStatus, len(g.members))
+
+	for i, m := range g.members {
+		statuses[i] = MemberStatus{URL: m.url, Ejected: m.ejected, InFlight: m.inFlight}
+	}
+
+	return statuses
+}
+
+func validateHashKey(key string) error {
+	switch {
+	case key == "", key == "path", key == "remote-addr":
+		return nil
+	case strings.HasPrefix(key, "header:") && key != "header:", strings.HasPrefix(key, "query:") && key != "query:":
+		return nil
+	default:
+		return fmt.Errorf("invalid hash key '%s', expected 'header:<name>', 'query:<name>', 'path' or 'remote-addr'", key)
+	}
+}
+
+// The value that is hashed to pick a member. Keys are 'header:<name>', 'query:<name>', 'path' or 'remote-addr' (default).
+func hashKeyValue(req *Request, key string) string {
+	r := req.originalRequest
+
+	switch {
+	case strings.HasPrefix(key, "header:"):
+		return r.Header.Get(strings.TrimPrefix(key, "header:"))
+	case strings.HasPrefix(key, "query:"):
+		return r.URL.Query().Get(strings.TrimPrefix(key, "query:"))
+	case key == "path":
+		return r.URL.Path
+	default:
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			return r.RemoteAddr
+		}
+
+		return host
+	}
+}
@@ -0,0 +1,151 @@
+package mirror
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rb3ckers/trafficmirror/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func mkGroup(t *testing.T, balance string, urls ...string) *group {
+	members := make([]*member, len(urls))
+	for i, url := range urls {
+		members[i] = &member{url: url, client: http.DefaultClient}
+	}
+
+	g, err := newGroup(members, balance, "header:X-User", 2, "/", 10*time.Millisecond)
+	assert.NoError(t, err)
+
+	return g
+}
+
+func mkGroupRequest(user string) *Request {
+	req := mkRequest(1, []uint64{})
+	req.originalRequest = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.originalRequest.Header.Set("X-User", user)
+
+	return req
+}
+
+func TestGroupRequiresPositiveHealthCheckInterval(t *testing.T) {
+	_, err := newGroup([]*member{{url: "http://a"}}, BalanceRoundRobin, "", 2, "/", 0)
+	assert.Error(t, err)
+}
+
+func TestGroupRejectsUnknownHashKey(t *testing.T) {
+	for _, key := range []string{"haeder:X-User", "header:", "cookie:session", "Path"} {
+		_, err := newGroup([]*member{{url: "http://a"}}, BalanceHash, key, 2, "/", time.Second)
+		assert.Error(t, err, key)
+	}
+
+	for _, key := range []string{"", "path", "remote-addr", "query:user"} {
+		_, err := newGroup([]*member{{url: "http://a"}}, BalanceHash, key, 2, "/", time.Second)
+		assert.NoError(t, err, key)
+	}
+}
+
+func TestGroupRoundRobin(t *testing.T) {
+	g := mkGroup(t, BalanceRoundRobin, "a", "b")
+
+	first, _ := g.pick(mkGroupRequest("x"))
+	second, _ := g.pick(mkGroupRequest("x"))
+	third, _ := g.pick(mkGroupRequest("x"))
+
+	assert.NotEqual(t, first.url, second.url)
+	assert.Equal(t, first.url, third.url)
+}
+
+func TestGroupLeastInFlight(t *testing.T) {
+	g := mkGroup(t, BalanceLeastInFlight, "a", "b")
+
+	busy, _ := g.pick(mkGroupRequest("x"))
+
+	for i := 0; i < 3; i++ {
+		picked, _ := g.pick(mkGroupRequest("x"))
+		assert.NotEqual(t, busy.url, picked.url)
+		g.completed(picked, http.StatusOK, nil)
+	}
+}
+
+func TestGroupHash(t *testing.T) {
+	g := mkGroup(t, BalanceHash, "a", "b", "c")
+
+	first, _ := g.pick(mkGroupRequest("user-1"))
+
+	for i := 0; i < 5; i++ {
+		picked, _ := g.pick(mkGroupRequest("user-1"))
+		assert.Equal(t, first.url, picked.url)
+	}
+}
+
+func TestGroupEjectsFailingMember(t *testing.T) {
+	var healthy int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&healthy) == 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	g := mkGroup(t, BalanceRoundRobin, server.URL)
+	m := g.members[0]
+
+	assert.False(t, g.completed(m, http.StatusServiceUnavailable, nil))
+	assert.True(t, g.completed(m, 0, errors.New("connection reset")))
+
+	_, err := g.pick(mkGroupRequest("x"))
+	assert.Error(t, err)
+
+	doneCh := make(chan struct{})
+	defer close(doneCh)
+
+	go g.probe(m, doneCh)
+
+	atomic.StoreInt32(&healthy, 1)
+
+	assert.Eventually(t, func() bool {
+		_, err := g.pick(mkGroupRequest("x"))
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestGroupSendsEachRequestToOneMember(t *testing.T) {
+	var requests int32
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+	})
+
+	member1 := httptest.NewServer(handler)
+	defer member1.Close()
+
+	member2 := httptest.NewServer(handler)
+	defer member2.Close()
+
+	target := &config.Target{Name: "shadow", Members: []string{member1.URL, member2.URL}}
+
+	m, err := NewMirror(target, config.Default(), make(chan string), make(chan string), MakeSendQueue(5))
+	assert.NoError(t, err)
+
+	defer m.Close()
+
+	for epoch := uint64(1); epoch <= 4; epoch++ {
+		req := mkRequest(epoch, []uint64{})
+		req.originalRequest = httptest.NewRequest(http.MethodGet, "/", nil)
+		m.Reflect(req)
+	}
+
+	assert.Eventually(t, func() bool {
+		epoch, _ := m.sendQueue.QueueStatus()
+		return epoch == 4
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, int32(4), atomic.LoadInt32(&requests))
+	assert.Equal(t, "shadow", m.GetStatus().URL)
+	assert.Len(t, m.GetStatus().Members, 2)
+}
@@ -0,0 +1,421 @@
+package mirror
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rb3ckers/trafficmirror/internal/config"
+)
+
+const (
+	RewritePath  = "path"
+	RewriteQuery = "query"
+	RewriteJSON  = "json"
+)
+
+const CaptureLocation = "location"
+
+const (
+	defaultIDIdleTimeout = time.Hour
+	defaultIDMaxEntries  = 100000
+)
+
+// Response bodies larger than this are not searched for IDs
+const MaxCaptureBody = 1 << 20
+
+// Maps the IDs of resources the main target created to the IDs the target created for the same request, and
+// rewrites them in later requests. Requests that depend on a created resource are sent after it is created, the send
+// queue keeps the order of requests that did not run concurrently. IDs are mapped per capture rule, and only
+// rewritten where that rule's IDs are expected: in paths under the path of the rule, and in query parameters and JSON
+// fields named after the ID field, so unrelated values like '?page=1' are left alone.
+type idMapper struct {
+	captures     []idCapture
+	rewritePath  bool
+	rewriteQuery bool
+	rewriteJSON  bool
+	mappings     *mappingTable
+}
+
+type idCapture struct {
+	method   string
+	path     string
+	location bool
+	jsonPath []string
+	// Name of the field holding the ID, query parameters and JSON fields are rewritten when named like it
+	field string
+}
+
+func newIDMapper(cfg config.IDMapping) (*idMapper, error) {
+	if len(cfg.Capture) == 0 {
+		return nil, nil
+	}
+
+	idleTimeout := cfg.IdleTimeout
+	if idleTimeout <= 0 {
+		idleTimeout = defaultIDIdleTimeout
+	}
+
+	maxEntries := cfg.MaxEntries
+	if maxEntries <= 0 {
+		maxEntries = defaultIDMaxEntries
+	}
+
+	m := &idMapper{mappings: newMappingTable(idleTimeout, maxEntries)}
+
+	for _, capture := range cfg.Capture {
+		c := idCapture{method: strings.ToUpper(capture.Method), path: capture.Path}
+
+		switch {
+		case capture.From == "":
+			return nil, fmt.Errorf("an ID capture needs a JSON path or '%s'", CaptureLocation)
+		case strings.ToLower(capture.From) == CaptureLocation:
+			c.location = true
+			c.field = "id"
+		default:
+			c.jsonPath = strings.Split(capture.From, ".")
+			c.field = c.jsonPath[len(c.jsonPath)-1]
+		}
+
+		m.captures = append(m.captures, c)
+	}
+
+	if len(cfg.Rewrite) == 0 {
+		m.rewritePath, m.rewriteQuery, m.rewriteJSON = true, true, true
+	}
+
+	for _, rewrite := range cfg.Rewrite {
+		switch strings.ToLower(rewrite) {
+		case RewritePath:
+			m.rewritePath = true
+		case RewriteQuery:
+			m.rewriteQuery = true
+		case RewriteJSON:
+			m.rewriteJSON = true
+		default:
+			return nil, fmt.Errorf("invalid ID rewrite '%s', expected '%s', '%s' or '%s'", rewrite, RewritePath, RewriteQuery, RewriteJSON)
+		}
+	}
+
+	return m, nil
+}
+
+func (c idCapture) matches(req *http.Request) bool {
+	return (c.method == "" || c.method == req.Method) && strings.HasPrefix(req.URL.Path, c.path)
+}
+
+// Whether a query parameter or JSON field with the name refers to IDs of the capture: 'id' for a field 'id', but also
+// 'orderId', 'order_id' and 'ids'.
+func (c idCapture) fieldMatches(name string) bool {
+	if len(name) > len(c.field) && strings.HasSuffix(name, "s") && c.fieldMatches(name[:len(name)-1]) {
+		return true
+	}
+
+	if strings.EqualFold(name, c.field) {
+		return true
+	}
+
+	if len(name) <= len(c.field) || !strings.EqualFold(name[len(name)-len(c.field):], c.field) {
+		return false
+	}
+
+	prefix, suffix := name[:len(name)-len(c.field)], name[len(name)-len(c.field):]
+
+	return strings.HasSuffix(prefix, "_") || strings.HasSuffix(prefix, "-") || (suffix[0] >= 'A' && suffix[0] <= 'Z')
+}
+
+// Mappings are kept per capture rule, equal IDs of different kinds of resources do not mix
+func idKey(rule int, id string) string {
+	return strconv.Itoa(rule) + ":" + id
+}
+
+// Returns the mapped ID for the first of the rules that has one
+func (m *idMapper) lookup(rules []int, id string) (string, bool) {
+	for _, rule := range rules {
+		if mapped, ok := m.mappings.get(idKey(rule, id)); ok {
+			return mapped, true
+		}
+	}
+
+	return "", false
+}
+
+// The rules whose IDs are expected in the path
+func (m *idMapper) pathRules(requestPath string) []int {
+	var rules []int
+
+	for i, capture := range m.captures {
+		if strings.HasPrefix(requestPath, capture.path) {
+			rules = append(rules, i)
+		}
+	}
+
+	return rules
+}
+
+// The rules whose IDs are expected in a query parameter or JSON field with the name
+func (m *idMapper) fieldRules(name string) []int {
+	var rules []int
+
+	for i, capture := range m.captures {
+		if capture.fieldMatches(name) {
+			rules = append(rules, i)
+		}
+	}
+
+	return rules
+}
+
+// Whether the response of the target to the request can contain IDs to capture
+func (m *idMapper) wantsResponse(req *Request) bool {
+	if req.main == nil {
+		return false
+	}
+
+	for _, capture := range m.captures {
+		if capture.matches(req.originalRequest) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Whether an ID is captured from the body of the response to the request
+func (m *idMapper) capturesBody(req *http.Request) bool {
+	for _, capture := range m.captures {
+		if !capture.location && capture.matches(req) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Maps the IDs in the response of the main target to those in the response of the target.
+func (m *idMapper) record(req *Request, response *http.Response, body []byte) {
+	mainBody := decodeBody(req.main.ContentEncoding, req.main.Body)
+	mirrorBody := decodeBody(response.Header.Get("Content-Encoding"), body)
+
+	for i, capture := range m.captures {
+		if !capture.matches(req.originalRequest) {
+			continue
+		}
+
+		mainID := capture.extract(req.main.Location, mainBody)
+		mirrorID := capture.extract(response.Header.Get("Location"), mirrorBody)
+
+		if mainID != "" && mirrorID != "" && mainID != mirrorID {
+			m.mappings.set(idKey(i, mainID), mirrorID)
+		}
+	}
+}
+
+func (c idCapture) extract(location string, body []byte) string {
+	if c.location {
+		locationURL, err := url.Parse(location)
+		if err != nil || locationURL.Path == "" {
+			return ""
+		}
+
+		id := path.Base(strings.TrimSuffix(locationURL.Path, "/"))
+		if id == "/" || id == "." {
+			return ""
+		}
+
+		return id
+	}
+
+	if len(body) == 0 {
+		return ""
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return ""
+	}
+
+	for _, key := range c.jsonPath {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return ""
+		}
+
+		value = object[key]
+	}
+
+	switch v := value.(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	default:
+		return ""
+	}
+}
+
+// Returns the request URI and body with the mapped IDs replaced. Path segments, query values and JSON values are
+// only replaced when they equal a mapped ID of a rule that applies to them.
+func (m *idMapper) rewrite(requestURI, contentType string, body []byte) (string, []byte) {
+	if m.mappings.len() == 0 {
+		return requestURI, body
+	}
+
+	requestPath, query, hasQuery := strings.Cut(requestURI, "?")
+
+	if rules := m.pathRules(requestPath); m.rewritePath && len(rules) > 0 {
+		segments := strings.Split(requestPath, "/")
+		for i, segment := range segments {
+			unescaped, err := url.PathUnescape(segment)
+			if err != nil || unescaped == "" {
+				continue
+			}
+
+			if id, ok := m.lookup(rules, unescaped); ok {
+				segments[i] = url.PathEscape(id)
+			}
+		}
+
+		requestPath = strings.Join(segments, "/")
+	}
+
+	if hasQuery && m.rewriteQuery {
+		query = m.rewriteQueryValues(query)
+	}
+
+	if m.rewriteJSON && len(body) > 0 {
+		if mediaType, _, _ := mime.ParseMediaType(contentType); mediaType == "application/json" || strings.HasSuffix(mediaType, "+json") {
+			body = m.rewriteJSONBody(body)
+		}
+	}
+
+	if hasQuery {
+		return requestPath + "?" + query, body
+	}
+
+	return requestPath, body
+}
+
+func (m *idMapper) rewriteQueryValues(query string) string {
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return query
+	}
+
+	rewritten := false
+
+	for name, vs := range values {
+		rules := m.fieldRules(name)
+		if len(rules) == 0 {
+			continue
+		}
+
+		for i, v := range vs {
+			if id, ok := m.lookup(rules, v); ok {
+				vs[i] = id
+				rewritten = true
+			}
+		}
+	}
+
+	// Encoding sorts the parameters, so only when something changed
+	if !rewritten {
+		return query
+	}
+
+	return values.Encode()
+}
+
+func (m *idMapper) rewriteJSONBody(body []byte) []byte {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+
+	var document interface{}
+	if err := decoder.Decode(&document); err != nil {
+		return body
+	}
+
+	document, rewritten := m.rewriteJSONValue(document, nil)
+	if !rewritten {
+		return body
+	}
+
+	encoded, err := json.Marshal(document)
+	if err != nil {
+		return body
+	}
+
+	return encoded
+}
+
+// Rewrites the IDs in the value, the rules are those of the field the value belongs to.
+func (m *idMapper) rewriteJSONValue(value interface{}, rules []int) (interface{}, bool) {
+	rewritten := false
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			var changed bool
+			if v[key], changed = m.rewriteJSONValue(child, m.fieldRules(key)); changed {
+				rewritten = true
+			}
+		}
+	case []interface{}:
+		// The elements belong to the field of the array
+		for i, child := range v {
+			var changed bool
+			if v[i], changed = m.rewriteJSONValue(child, rules); changed {
+				rewritten = true
+			}
+		}
+	case string:
+		if id, ok := m.lookup(rules, v); ok {
+			return id, true
+		}
+	case json.Number:
+		if id, ok := m.lookup(rules, v.String()); ok {
+			// Numeric IDs stay numbers, as far as the target's IDs are numbers too
+			if _, err := json.Number(id).Float64(); err == nil {
+				return json.Number(id), true
+			}
+
+			return id, true
+		}
+	}
+
+	return value, rewritten
+}
+
+// Returns the body without its content encoding, or nil when the encoding is not supported
+func decodeBody(encoding string, body []byte) []byte {
+	switch strings.ToLower(encoding) {
+	case "", "identity":
+		return body
+	case "gzip":
+		reader, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil
+		}
+
+		decoded, err := io.ReadAll(io.LimitReader(reader, MaxCaptureBody))
+		if err != nil {
+			return nil
+		}
+
+		return decoded
+	default:
+		return nil
+	}
+}
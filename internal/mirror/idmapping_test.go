@@ -0,0 +1,165 @@
+package mirror
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rb3ckers/trafficmirror/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func mkIDMapper(t *testing.T, cfg config.IDMapping) *idMapper {
+	m, err := newIDMapper(cfg)
+	assert.NoError(t, err)
+
+	return m
+}
+
+func TestNewIDMapperValidates(t *testing.T) {
+	assert.Nil(t, mkIDMapper(t, config.IDMapping{}))
+
+	_, err := newIDMapper(config.IDMapping{Capture: []config.IDCapture{{Method: "POST"}}})
+	assert.Error(t, err)
+
+	_, err = newIDMapper(config.IDMapping{Capture: []config.IDCapture{{From: "id"}}, Rewrite: []string{"header"}})
+	assert.Error(t, err)
+}
+
+func TestIDCaptureExtract(t *testing.T) {
+	location := idCapture{location: true}
+	assert.Equal(t, "123", location.extract("/orders/123", nil))
+	assert.Equal(t, "123", location.extract("https://shop.example.com/orders/123/", nil))
+	assert.Equal(t, "", location.extract("", nil))
+
+	json := idCapture{jsonPath: []string{"data", "id"}}
+	assert.Equal(t, "123", json.extract("", []byte(`{"data":{"id":123}}`)))
+	assert.Equal(t, "a-1", json.extract("", []byte(`{"data":{"id":"a-1"}}`)))
+	assert.Equal(t, "", json.extract("", []byte(`{"data":{"id":{}}}`)))
+	assert.Equal(t, "", json.extract("", []byte(`{"data":[1]}`)))
+	assert.Equal(t, "", json.extract("", []byte(`not json`)))
+}
+
+func TestIDMapperRewrite(t *testing.T) {
+	m := mkIDMapper(t, config.IDMapping{Capture: []config.IDCapture{{From: "id"}}})
+	m.mappings.set(idKey(0, "123"), "987")
+	m.mappings.set(idKey(0, "a b"), "c/d")
+
+	uri, body := m.rewrite("/orders/123/items?order_id=123&page=2", "application/json", []byte(`{"orderId":123,"ids":["123",7],"name":"x"}`))
+	assert.Equal(t, "/orders/987/items?order_id=987&page=2", uri)
+	assert.JSONEq(t, `{"orderId":987,"ids":["987",7],"name":"x"}`, string(body))
+
+	uri, _ = m.rewrite("/tags/a%20b", "", nil)
+	assert.Equal(t, "/tags/c%2Fd", uri)
+
+	// Nothing mapped, nothing changed
+	original := []byte(`{"b":1, "a":2}`)
+	uri, body = m.rewrite("/orders/5?b=1&a=2", "application/json", original)
+	assert.Equal(t, "/orders/5?b=1&a=2", uri)
+	assert.Equal(t, original, body)
+}
+
+func TestIDMapperRewriteOnlyWhereConfigured(t *testing.T) {
+	m := mkIDMapper(t, config.IDMapping{Capture: []config.IDCapture{{From: "id"}}, Rewrite: []string{RewritePath}})
+	m.mappings.set(idKey(0, "123"), "987")
+
+	uri, body := m.rewrite("/orders/123?id=123", "application/json", []byte(`{"id":123}`))
+	assert.Equal(t, "/orders/987?id=123", uri)
+	assert.Equal(t, `{"id":123}`, string(body))
+}
+
+func TestIDMapperRewriteOnlyWhereIDsAreExpected(t *testing.T) {
+	m := mkIDMapper(t, config.IDMapping{Capture: []config.IDCapture{
+		{Path: "/orders", From: CaptureLocation},
+		{Path: "/customers", From: "data.number"},
+	}})
+	m.mappings.set(idKey(0, "1"), "987")
+	m.mappings.set(idKey(1, "1"), "555")
+
+	// Not under the path of the rule, and not named like the ID field
+	uri, body := m.rewrite("/products/1?page=1&paid=1", "application/json", []byte(`{"quantity":1,"paid":1}`))
+	assert.Equal(t, "/products/1?page=1&paid=1", uri)
+	assert.Equal(t, `{"quantity":1,"paid":1}`, string(body))
+
+	uri, body = m.rewrite("/orders/1?customerNumber=1", "application/json", []byte(`{"orderID":1,"customer-number":1}`))
+	assert.Equal(t, "/orders/987?customerNumber=555", uri)
+	assert.JSONEq(t, `{"orderID":987,"customer-number":555}`, string(body))
+
+	uri, _ = m.rewrite("/customers/1", "", nil)
+	assert.Equal(t, "/customers/555", uri)
+}
+
+func TestIDMapperRecordsGzippedBodies(t *testing.T) {
+	m := mkIDMapper(t, config.IDMapping{Capture: []config.IDCapture{{Method: "POST", From: "id"}}})
+
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	writer.Write([]byte(`{"id":987}`)) //nolint:errcheck
+	writer.Close()
+
+	req := mkRequest(1, []uint64{})
+	req.originalRequest = httptest.NewRequest(http.MethodPost, "/orders", nil)
+	req.main = &MainResponse{StatusCode: http.StatusCreated, Body: []byte(`{"id":123}`)}
+
+	assert.True(t, m.wantsResponse(req))
+	assert.True(t, m.capturesBody(req.originalRequest))
+	assert.False(t, m.capturesBody(httptest.NewRequest(http.MethodGet, "/orders", nil)))
+
+	m.record(req, &http.Response{Header: http.Header{"Content-Encoding": {"gzip"}}}, compressed.Bytes())
+
+	id, ok := m.mappings.get(idKey(0, "123"))
+	assert.True(t, ok)
+	assert.Equal(t, "987", id)
+
+	// Other methods are not captured
+	req.originalRequest = httptest.NewRequest(http.MethodGet, "/orders", nil)
+	assert.False(t, m.wantsResponse(req))
+}
+
+func TestIDMappingOnTarget(t *testing.T) {
+	received := make(chan string, 2)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- r.URL.RequestURI() + " " + string(body)
+
+		if r.Method == http.MethodPost {
+			w.Header().Set("Location", "/orders/987")
+			w.WriteHeader(http.StatusCreated)
+		}
+	}))
+	defer server.Close()
+
+	r := NewReflector(config.Default())
+	assert.NoError(t, r.AddTargets([]*config.Target{{URL: server.URL, IDMapping: config.IDMapping{
+		Capture: []config.IDCapture{{Method: "POST", Path: "/orders", From: CaptureLocation}},
+	}}}))
+	// The ID is in the Location header, the body is not needed
+	assert.False(t, r.CapturesMainResponseBody(httptest.NewRequest(http.MethodPost, "/orders", nil)))
+
+	create := mkRequest(1, []uint64{})
+	create.originalRequest = httptest.NewRequest(http.MethodPost, "/orders", nil)
+	create.main = &MainResponse{StatusCode: http.StatusCreated, Location: "/orders/123"}
+	r.sendToMirrors(create)
+
+	assert.Equal(t, "/orders ", <-received)
+
+	read := mkRequest(2, []uint64{})
+	read.originalRequest = httptest.NewRequest(http.MethodPut, "/orders/123", nil)
+	read.originalRequest.Header.Set("Content-Type", "application/json")
+	read.body = []byte(`{"id":123}`)
+	r.sendToMirrors(read)
+
+	select {
+	case request := <-received:
+		assert.Equal(t, `/orders/987 {"id":987}`, request)
+	case <-time.After(time.Second):
+		t.Fatal("request was not mirrored")
+	}
+
+	assert.Equal(t, 1, r.ListMirrors()[0].MappedIDs)
+}
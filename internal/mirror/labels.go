@@ -0,0 +1,94 @@
+package mirror
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type requirement struct {
+	key    string
+	value  string
+	negate bool
+	exists bool // Only check for the presence of the key
+}
+
+// Selector selects targets by their labels. All requirements need to match.
+type Selector []requirement
+
+// ParseSelector parses label requirements: 'key=value', 'key!=value', 'key' (has the label) or '!key' (does not have the label).
+func ParseSelector(requirements []string) (Selector, error) {
+	selector := make(Selector, 0, len(requirements))
+
+	for _, r := range requirements {
+		for _, part := range strings.Split(r, ",") {
+			part = strings.TrimSpace(part)
+
+			switch {
+			case part == "" || part == "!":
+				return nil, fmt.Errorf("invalid label selector '%s'", r)
+			case strings.Contains(part, "!="):
+				kv := strings.SplitN(part, "!=", 2) //nolint:gomnd
+				selector = append(selector, requirement{key: kv[0], value: kv[1], negate: true})
+			case strings.Contains(part, "="):
+				kv := strings.SplitN(part, "=", 2) //nolint:gomnd
+				selector = append(selector, requirement{key: kv[0], value: kv[1]})
+			case strings.HasPrefix(part, "!"):
+				selector = append(selector, requirement{key: part[1:], exists: true, negate: true})
+			default:
+				selector = append(selector, requirement{key: part, exists: true})
+			}
+		}
+	}
+
+	return selector, nil
+}
+
+func (s Selector) Matches(labels map[string]string) bool {
+	for _, r := range s {
+		value, ok := labels[r.key]
+
+		var matches bool
+		if r.exists {
+			matches = ok
+		} else {
+			matches = ok && value == r.value
+		}
+
+		if matches == r.negate {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Select returns the keys of the targets matching the selector.
+func (r *Reflector) Select(selector Selector) []string {
+	r.RLock()
+	defer r.RUnlock()
+
+	var keys []string
+
+	for key, mirror := range r.mirrors {
+		if selector.Matches(mirror.target.Labels) {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+// FormatLabels formats labels as sorted 'key=value' pairs.
+func FormatLabels(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for k, v := range labels {
+		pairs = append(pairs, k+"="+v)
+	}
+
+	sort.Strings(pairs)
+
+	return strings.Join(pairs, ",")
+}
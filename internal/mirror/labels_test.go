@@ -0,0 +1,40 @@
+package mirror
+
+import (
+	"testing"
+
+	"github.com/rb3ckers/trafficmirror/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelectorMatches(t *testing.T) {
+	labels := map[string]string{"team": "payments", "env": "staging"}
+
+	for selector, expected := range map[string]bool{
+		"team=payments":           true,
+		"team=search":             false,
+		"team!=search":            true,
+		"team=payments,env=prod":  false,
+		"team=payments,env!=prod": true,
+		"env":                     true,
+		"owner":                   false,
+		"!owner":                  true,
+	} {
+		s, err := ParseSelector([]string{selector})
+		assert.NoError(t, err)
+		assert.Equal(t, expected, s.Matches(labels), selector)
+	}
+}
+
+func TestReflectorSelect(t *testing.T) {
+	r := NewReflector(config.Default())
+	assert.NoError(t, r.AddTargets([]*config.Target{
+		{URL: "http://a:8080", Labels: map[string]string{"team": "payments"}},
+		{URL: "http://b:8080", Labels: map[string]string{"team": "search"}},
+		{URL: "http://c:8080", Labels: map[string]string{"team": "payments"}},
+	}))
+
+	s, err := ParseSelector([]string{"team=payments"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"http://a:8080", "http://c:8080"}, r.Select(s))
+}
@@ -0,0 +1,93 @@
+package mirror
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/rb3ckers/trafficmirror/internal/config"
+)
+
+// LeaseSource is the source label of targets that registered themselves with a lease
+const LeaseSource = "lease"
+
+var ErrUnknownLease = errors.New("unknown or expired lease")
+
+// AddLease adds a target that is removed when its lease is not renewed in time. Returns the ID to renew the lease with.
+func (r *Reflector) AddLease(target *config.Target, lease time.Duration) (string, error) {
+	if lease <= 0 {
+		return "", fmt.Errorf("lease must be positive")
+	}
+
+	id, err := newLeaseID()
+	if err != nil {
+		return "", err
+	}
+
+	leased := *target
+	leased.TTL = lease
+	leased.Labels = make(map[string]string, len(target.Labels)+1)
+
+	for k, v := range target.Labels {
+		leased.Labels[k] = v
+	}
+
+	leased.Labels[config.SourceLabel] = LeaseSource
+
+	if err := r.AddTargets([]*config.Target{&leased}); err != nil {
+		return "", err
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	r.leases[id] = leased.Key()
+
+	log.Printf("Leased '%s' for %s.", leased.Key(), lease)
+
+	return id, nil
+}
+
+// RenewLease extends the lease to the given duration from now.
+func (r *Reflector) RenewLease(id string, lease time.Duration) error {
+	r.RLock()
+	defer r.RUnlock()
+
+	key, ok := r.leases[id]
+	if !ok {
+		return ErrUnknownLease
+	}
+
+	if mirror, ok := r.mirrors[key]; !ok || !mirror.extendTTL(lease) {
+		return ErrUnknownLease
+	}
+
+	return nil
+}
+
+// ReleaseLease removes the target of the lease.
+func (r *Reflector) ReleaseLease(id string) error {
+	r.RLock()
+	key, ok := r.leases[id]
+	r.RUnlock()
+
+	if !ok {
+		return ErrUnknownLease
+	}
+
+	r.RemoveMirrors([]string{key})
+
+	return nil
+}
+
+func newLeaseID() (string, error) {
+	b := make([]byte, 16) //nolint:gomnd
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,41 @@
+package mirror
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rb3ckers/trafficmirror/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLeaseRenewalKeepsTarget(t *testing.T) {
+	r := mkReflector(t)
+	defer r.Close()
+
+	id, err := r.AddLease(&config.Target{URL: "http://preview:8080"}, 100*time.Millisecond)
+	assert.NoError(t, err)
+	assert.Equal(t, LeaseSource, r.ListMirrors()[0].Labels[config.SourceLabel])
+
+	for i := 0; i < 4; i++ {
+		time.Sleep(50 * time.Millisecond)
+		assert.NoError(t, r.RenewLease(id, 100*time.Millisecond))
+	}
+
+	assert.True(t, r.HasTarget("http://preview:8080"))
+
+	// Stop renewing
+	assert.Eventually(t, func() bool { return !r.HasTarget("http://preview:8080") }, time.Second, 10*time.Millisecond)
+	assert.ErrorIs(t, r.RenewLease(id, time.Second), ErrUnknownLease)
+}
+
+func TestLeaseRelease(t *testing.T) {
+	r := mkReflector(t)
+	defer r.Close()
+
+	id, err := r.AddLease(&config.Target{URL: "http://preview:8080"}, time.Minute)
+	assert.NoError(t, err)
+
+	assert.NoError(t, r.ReleaseLease(id))
+	assert.False(t, r.HasTarget("http://preview:8080"))
+	assert.ErrorIs(t, r.ReleaseLease(id), ErrUnknownLease)
+}
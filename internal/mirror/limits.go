@@ -0,0 +1,83 @@
+package mirror
+
+import (
+	"log"
+	"time"
+)
+
+// Unlimited is the remaining request budget of targets without a request limit
+const Unlimited = -1
+
+// Starts tracking the time and request limits of the mirror
+func (m *Mirror) startLimits(ttl time.Duration, maxRequests int) {
+	m.remainingRequests = Unlimited
+	if maxRequests > 0 {
+		m.remainingRequests = maxRequests
+	}
+
+	if ttl > 0 {
+		m.expiresAt = time.Now().Add(ttl)
+		m.ttlTimer = time.AfterFunc(ttl, func() {
+			log.Printf("Target %s reached its time limit of %s.", m.targetURL, ttl)
+			m.expire()
+		})
+	}
+}
+
+// Takes a request from the request budget, returns false when the budget is exhausted.
+func (m *Mirror) takeRequestBudget() bool {
+	m.Lock()
+	defer m.Unlock()
+
+	if m.remainingRequests == Unlimited {
+		return true
+	}
+
+	if m.remainingRequests == 0 {
+		return false
+	}
+
+	m.remainingRequests--
+
+	if m.remainingRequests == 0 {
+		log.Printf("Target %s reached its request limit.", m.targetURL)
+		m.expire()
+	}
+
+	return true
+}
+
+// Signals the reflector to remove the mirror, this does not block as it can be called while the reflector is
+// sending to the mirrors.
+func (m *Mirror) expire() {
+	m.expireOnce.Do(func() {
+		go func() {
+			select {
+			case m.expiredCh <- m.targetURL:
+			case <-m.doneCh:
+			}
+		}()
+	})
+}
+
+func (m *Mirror) stopLimits() {
+	if m.ttlTimer != nil {
+		m.ttlTimer.Stop()
+	}
+}
+
+// Extends the time limit to the given duration from now, returns false when the time limit was already reached or
+// the mirror has no time limit.
+func (m *Mirror) extendTTL(ttl time.Duration) bool {
+	m.Lock()
+	defer m.Unlock()
+
+	if m.ttlTimer == nil || !m.ttlTimer.Stop() {
+		return false
+	}
+
+	m.expiresAt = time.Now().Add(ttl)
+	m.ttlTimer.Reset(ttl)
+
+	return true
+}
@@ -0,0 +1,72 @@
+package mirror
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rb3ckers/trafficmirror/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func mkReflector(t *testing.T, targets ...*config.Target) *Reflector {
+	r := NewReflector(config.Default())
+	assert.NoError(t, r.AddTargets(targets))
+
+	go r.Reflect()
+
+	return r
+}
+
+func TestTargetRemovedAfterMaxRequests(t *testing.T) {
+	var requests int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+	}))
+	defer server.Close()
+
+	r := mkReflector(t, &config.Target{URL: server.URL, MaxRequests: 2})
+	defer r.Close()
+
+	assert.Equal(t, 2, r.ListMirrors()[0].RemainingRequests)
+
+	for epoch := uint64(1); epoch <= 3; epoch++ {
+		req := mkRequest(epoch, []uint64{})
+		req.originalRequest = httptest.NewRequest(http.MethodGet, "/", nil)
+		r.IncomingCh <- req
+	}
+
+	assert.Eventually(t, func() bool { return !r.HasTarget(server.URL) }, time.Second, 10*time.Millisecond)
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&requests) == 2 }, time.Second, 10*time.Millisecond)
+}
+
+func TestSkippedRequestsDoNotCountAgainstMaxRequests(t *testing.T) {
+	var requests int32
+
+	server := mkCountingServer(&requests)
+	defer server.Close()
+
+	r := NewReflector(config.Default())
+	assert.NoError(t, r.AddTargets([]*config.Target{{URL: server.URL, MaxRequests: 5, MainResponse: config.MainResponseConditions{Status: []string{"2xx"}}}}))
+
+	for epoch := uint64(1); epoch <= 3; epoch++ {
+		req := mkGetRequest(epoch)
+		req.SetMainResponse(&MainResponse{StatusCode: http.StatusInternalServerError})
+		r.sendToMirrors(req)
+	}
+
+	assert.Eventually(t, func() bool { return r.ListMirrors()[0].Epoch == 3 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, 5, r.ListMirrors()[0].RemainingRequests)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&requests))
+}
+
+func TestTargetRemovedAfterTTL(t *testing.T) {
+	r := mkReflector(t, &config.Target{URL: "http://localhost:1", TTL: 50 * time.Millisecond})
+	defer r.Close()
+
+	assert.False(t, r.ListMirrors()[0].ExpiresAt.IsZero())
+	assert.Eventually(t, func() bool { return !r.HasTarget("http://localhost:1") }, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,123 @@
+package mirror
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/rb3ckers/trafficmirror/internal/config"
+)
+
+const (
+	MainStatusHeader  = "X-Main-Status"
+	MainLatencyHeader = "X-Main-Latency"
+)
+
+// Either a status class like 2xx or an exact status code
+type statusPattern struct {
+	class int
+	code  int
+}
+
+func parseStatusPattern(pattern string) (statusPattern, error) {
+	lower := strings.ToLower(pattern)
+
+	if len(lower) == 3 && strings.HasSuffix(lower, "xx") && lower[0] >= '1' && lower[0] <= '5' {
+		return statusPattern{class: int(lower[0] - '0')}, nil
+	}
+
+	code, err := strconv.Atoi(pattern)
+	if err != nil || code < 100 || code > 599 {
+		return statusPattern{}, fmt.Errorf("invalid status '%s', expected a code like 404 or a class like 2xx", pattern)
+	}
+
+	return statusPattern{code: code}, nil
+}
+
+func (p statusPattern) matches(statusCode int) bool {
+	if p.class > 0 {
+		return statusCode/100 == p.class
+	}
+
+	return statusCode == p.code
+}
+
+// Decides whether a request is mirrored based on the response of the main target
+type mainConditions struct {
+	status           []statusPattern
+	skipStatus       []statusPattern
+	skipDisconnected bool
+	forwardHeaders   bool
+}
+
+func parseStatusPatterns(patterns []string) ([]statusPattern, error) {
+	parsed := make([]statusPattern, 0, len(patterns))
+
+	for _, pattern := range patterns {
+		p, err := parseStatusPattern(pattern)
+		if err != nil {
+			return nil, err
+		}
+
+		parsed = append(parsed, p)
+	}
+
+	return parsed, nil
+}
+
+func newMainConditions(cfg config.MainResponseConditions) (*mainConditions, error) {
+	c := &mainConditions{skipDisconnected: cfg.SkipDisconnected, forwardHeaders: cfg.ForwardHeaders}
+
+	var err error
+
+	if c.status, err = parseStatusPatterns(cfg.Status); err != nil {
+		return nil, err
+	}
+
+	if c.skipStatus, err = parseStatusPatterns(cfg.SkipStatus); err != nil {
+		return nil, err
+	}
+
+	if len(c.status) == 0 && len(c.skipStatus) == 0 && !c.skipDisconnected && !c.forwardHeaders {
+		return nil, nil
+	}
+
+	return c, nil
+}
+
+func anyMatches(patterns []statusPattern, statusCode int) bool {
+	for _, p := range patterns {
+		if p.matches(statusCode) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns whether the request should be mirrored. Without a main response only unconditional targets get it.
+func (c *mainConditions) allow(main *MainResponse) bool {
+	if main == nil {
+		return len(c.status) == 0 && len(c.skipStatus) == 0 && !c.skipDisconnected
+	}
+
+	if c.skipDisconnected && main.ClientDisconnected {
+		return false
+	}
+
+	if len(c.status) > 0 && !anyMatches(c.status, main.StatusCode) {
+		return false
+	}
+
+	return !anyMatches(c.skipStatus, main.StatusCode)
+}
+
+func (c *mainConditions) addHeaders(header http.Header, main *MainResponse) {
+	if !c.forwardHeaders || main == nil {
+		return
+	}
+
+	header.Set(MainStatusHeader, strconv.Itoa(main.StatusCode))
+	header.Set(MainLatencyHeader, strconv.FormatInt(main.Latency.Milliseconds(), 10))
+}
@@ -0,0 +1,86 @@
+package mirror
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rb3ckers/trafficmirror/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMainResponseConditions(t *testing.T) {
+	c, err := newMainConditions(config.MainResponseConditions{Status: []string{"2xx", "404"}, SkipStatus: []string{"204"}, SkipDisconnected: true})
+	assert.NoError(t, err)
+
+	assert.True(t, c.allow(&MainResponse{StatusCode: http.StatusOK}))
+	assert.True(t, c.allow(&MainResponse{StatusCode: http.StatusNotFound}))
+	assert.False(t, c.allow(&MainResponse{StatusCode: http.StatusNoContent}))
+	assert.False(t, c.allow(&MainResponse{StatusCode: http.StatusInternalServerError}))
+	assert.False(t, c.allow(&MainResponse{StatusCode: http.StatusOK, ClientDisconnected: true}))
+	assert.False(t, c.allow(nil))
+}
+
+func TestInvalidStatusPattern(t *testing.T) {
+	for _, pattern := range []string{"2x", "6xx", "abc", "99"} {
+		_, err := newMainConditions(config.MainResponseConditions{SkipStatus: []string{pattern}})
+		assert.Error(t, err, pattern)
+	}
+}
+
+func TestMirrorSkipsFailedMainRequests(t *testing.T) {
+	var headers []http.Header
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = append(headers, r.Header)
+	}))
+	defer server.Close()
+
+	r := NewReflector(config.Default())
+	assert.NoError(t, r.AddTargets([]*config.Target{{
+		URL:          server.URL,
+		MainResponse: config.MainResponseConditions{SkipStatus: []string{"5xx"}, ForwardHeaders: true},
+	}}))
+
+	failed := mkGetRequest(1)
+	failed.SetMainResponse(&MainResponse{StatusCode: http.StatusBadGateway})
+	r.sendToMirrors(failed)
+
+	succeeded := mkGetRequest(2)
+	succeeded.SetMainResponse(&MainResponse{StatusCode: http.StatusCreated, Latency: 42 * time.Millisecond})
+	r.sendToMirrors(succeeded)
+
+	assert.Eventually(t, func() bool { return r.ListMirrors()[0].Epoch == 2 }, time.Second, 10*time.Millisecond)
+
+	if assert.Len(t, headers, 1) {
+		assert.Equal(t, "201", headers[0].Get(MainStatusHeader))
+		assert.Equal(t, "42", headers[0].Get(MainLatencyHeader))
+		assert.Equal(t, hopID(config.Default()), headers[0].Get("X-Mirrored-By"))
+	}
+}
+
+func TestSkippedRequestReleasesQueuedRequests(t *testing.T) {
+	var headers []http.Header
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = append(headers, r.Header)
+	}))
+	defer server.Close()
+
+	r := NewReflector(config.Default())
+	assert.NoError(t, r.AddTargets([]*config.Target{{URL: server.URL, MainResponse: config.MainResponseConditions{SkipStatus: []string{"5xx"}}}}))
+
+	// Epoch 2 is queued until epoch 1 completes, which is skipped
+	succeeded := mkGetRequest(2)
+	succeeded.SetMainResponse(&MainResponse{StatusCode: http.StatusOK})
+	r.sendToMirrors(succeeded)
+
+	failed := mkGetRequest(1)
+	failed.SetMainResponse(&MainResponse{StatusCode: http.StatusBadGateway})
+	r.sendToMirrors(failed)
+
+	assert.Eventually(t, func() bool { return r.ListMirrors()[0].Epoch == 2 }, time.Second, 10*time.Millisecond)
+	assert.Len(t, headers, 1)
+	assert.Equal(t, 0, r.ListMirrors()[0].QueuedRequests)
+}
@@ -0,0 +1,100 @@
+package mirror
+
+import (
+	"sync"
+	"time"
+)
+
+// A bounded table mapping production values to the values of a target. Entries that are not used for the idle
+// timeout are forgotten, and when the table is full the least recently used entry makes room.
+type mappingTable struct {
+	sync.Mutex
+	idleTimeout time.Duration
+	maxEntries  int
+	entries     map[string]*mappingEntry
+	now         func() time.Time
+}
+
+type mappingEntry struct {
+	value  string
+	usedAt time.Time
+}
+
+func newMappingTable(idleTimeout time.Duration, maxEntries int) *mappingTable {
+	return &mappingTable{
+		idleTimeout: idleTimeout,
+		maxEntries:  maxEntries,
+		entries:     make(map[string]*mappingEntry),
+		now:         time.Now,
+	}
+}
+
+func (t *mappingTable) get(key string) (string, bool) {
+	t.Lock()
+	defer t.Unlock()
+
+	entry, ok := t.entries[key]
+	if !ok {
+		return "", false
+	}
+
+	now := t.now()
+	if now.Sub(entry.usedAt) > t.idleTimeout {
+		delete(t.entries, key)
+		return "", false
+	}
+
+	entry.usedAt = now
+
+	return entry.value, true
+}
+
+func (t *mappingTable) set(key, value string) {
+	t.Lock()
+	defer t.Unlock()
+
+	now := t.now()
+
+	if _, exists := t.entries[key]; !exists && len(t.entries) >= t.maxEntries {
+		t.evict(now)
+	}
+
+	t.entries[key] = &mappingEntry{value: value, usedAt: now}
+}
+
+func (t *mappingTable) delete(key string) {
+	t.Lock()
+	defer t.Unlock()
+
+	delete(t.entries, key)
+}
+
+// Forgets the idle entries, or the least recently used one when none are idle. Must be called with the lock held.
+func (t *mappingTable) evict(now time.Time) {
+	var (
+		oldestKey string
+		oldest    *mappingEntry
+	)
+
+	for key, entry := range t.entries {
+		if now.Sub(entry.usedAt) > t.idleTimeout {
+			delete(t.entries, key)
+			continue
+		}
+
+		if oldest == nil || entry.usedAt.Before(oldest.usedAt) {
+			oldestKey, oldest = key, entry
+		}
+	}
+
+	if len(t.entries) >= t.maxEntries && oldest != nil {
+		delete(t.entries, oldestKey)
+	}
+}
+
+func (t *mappingTable) len() int {
+	t.Lock()
+	defer t.Unlock()
+
+	return len(t.entries)
+}
@@ -2,36 +2,79 @@ package mirror
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
+	"os"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/rb3ckers/trafficmirror/internal/config"
+	"github.com/rb3ckers/trafficmirror/internal/redact"
 	"github.com/sony/gobreaker"
 )
 
 type Mirror struct {
 	sync.Mutex
+	target                   config.Target
 	netClient                *http.Client
 	targetURL                string
 	breaker                  *gobreaker.CircuitBreaker
 	firstFailureTime         time.Time
 	persistentFailureTimeout time.Duration
 	failureCh                chan<- string
+	failOnce                 sync.Once
 	sendQueue                *SendQueue
+	retry                    *retryPolicy
+	resolver                 *resolver
+	group                    *group
+	settings                 gobreaker.Settings
+	quarantineEnabled        bool
+	quarantinedSince         time.Time
+	healthCheckURL           string
+	healthCheckInterval      time.Duration
+	maxQuarantine            time.Duration
+	expiredCh                chan<- string
+	expireOnce               sync.Once
+	expiresAt                time.Time
+	ttlTimer                 *time.Timer
+	remainingRequests        int
+	paused                   bool
+	pauseMode                string
+	rateLimiter              *rateLimiter
+	quotas                   []*quota
+	quotaResetsAt            time.Time
+	ramp                     *ramp
+	amplifier                *amplifier
+	delayed                  *delayQueue
+	mainConditions           *mainConditions
+	hopHeader                string
+	hopID                    string
+	redactor                 *redact.Redactor
+	credentials              *credentials
+	signer                   signer
+	cookieJar                *cookieJar
+	idMapper                 *idMapper
+	webSocketMode            string
+	webSockets               int
+	webSocketMismatches      int
+	closeOnce                sync.Once
+	doneCh                   chan struct{}
 }
 
 type MirrorState string
 
 var (
-	StateFailing  MirrorState = "failing"
-	StateRetrying MirrorState = "retrying"
-	StateAlive    MirrorState = "alive"
-	StateUnkown   MirrorState = "unknown"
+	StateFailing     MirrorState = "failing"
+	StateRetrying    MirrorState = "retrying"
+	StateQuarantined MirrorState = "quarantined"
+	StateAlive       MirrorState = "alive"
+	StateUnkown      MirrorState = "unknown"
 )
 
 type MirrorStatus struct {
@@ -40,20 +83,171 @@ type MirrorStatus struct {
 	URL            string
 	QueuedRequests int
 	Epoch          uint64
+	Members        []MemberStatus
+	Labels         map[string]string
+	Metadata       map[string]string
+	Paused         bool
+	// Zero when the target has no time limit
+	ExpiresAt time.Time
+	// Unlimited when the target has no request limit
+	RemainingRequests int
+	// Zero unless the target is paused because its quota is used up
+	QuotaResetsAt time.Time
+	// Nil unless the target is ramping up
+	Ramp *RampStatus
+	// Requests waiting for the delay of the target to pass
+	DelayedRequests int
+	// Production cookies mapped to cookies of the target
+	MappedCookies int
+	// IDs of resources of the main target mapped to IDs of the target
+	MappedIDs int
+	// Open mirrored WebSocket connections, and the messages that differed from those of the main target
+	WebSockets          int
+	WebSocketMismatches int
 }
 
-func NewMirror(targetURL string, config *config.Config, failureCh chan<- string, persistent bool, sendQueue *SendQueue) *Mirror {
+func NewMirror(target *config.Target, config *config.Config, failureCh, expiredCh chan<- string, sendQueue *SendQueue) (*Mirror, error) {
+	targetURL := target.Key()
+
+	if config.Quarantine && config.HealthCheckInterval <= 0 {
+		return nil, fmt.Errorf("invalid health check interval %d, it must be positive", config.HealthCheckInterval)
+	}
+
+	clientProfile := target.Client
+	if clientProfile.Proxy == "" {
+		clientProfile.Proxy = config.MirrorProxy
+		clientProfile.ProxyFromEnvironment = clientProfile.ProxyFromEnvironment || config.MirrorProxyFromEnv
+	}
+
+	overrides, err := parseResolveOverrides(target.DNS.Resolve)
+	if err != nil {
+		return nil, err
+	}
+
 	retryAfter := time.Duration(config.RetryAfter) * time.Minute
 	persistentFailureTimeout := time.Duration(config.PersistentFailureTimeout) * time.Minute
 
 	mirror := &Mirror{
-		netClient: &http.Client{
-			Timeout: time.Second * 20,
-		},
+		target:                   *target,
 		persistentFailureTimeout: persistentFailureTimeout,
 		targetURL:                targetURL,
 		failureCh:                failureCh,
+		expiredCh:                expiredCh,
 		sendQueue:                sendQueue,
+		retry:                    newRetryPolicy(target.Retry),
+		quarantineEnabled:        config.Quarantine,
+		healthCheckURL:           targetURL + config.HealthCheckPath,
+		healthCheckInterval:      time.Duration(config.HealthCheckInterval) * time.Second,
+		maxQuarantine:            time.Duration(config.MaxQuarantine) * time.Minute,
+		hopHeader:                config.HopHeader,
+		hopID:                    hopID(config),
+		doneCh:                   make(chan struct{}),
+	}
+
+	if mirror.pauseMode, err = parsePauseMode(target.PauseMode); err != nil {
+		return nil, err
+	}
+
+	if mirror.webSocketMode, err = parseWebSocketMode(target.WebSocket); err != nil {
+		return nil, err
+	}
+
+	if mirror.rateLimiter, err = newRateLimiter(target.RateLimit); err != nil {
+		return nil, err
+	}
+
+	mirror.quotas = newQuotas(target.RateLimit)
+
+	if mirror.ramp, err = newRamp(targetURL, target.Ramp); err != nil {
+		return nil, err
+	}
+
+	if mirror.amplifier, err = newAmplifier(target.Amplify); err != nil {
+		return nil, err
+	}
+
+	if mirror.mainConditions, err = newMainConditions(target.MainResponse); err != nil {
+		return nil, err
+	}
+
+	if mirror.redactor, err = redact.New(target.Redact); err != nil {
+		return nil, err
+	}
+
+	if mirror.credentials, err = newCredentials(target.Credentials); err != nil {
+		return nil, err
+	}
+
+	if mirror.signer, err = newSigner(target.Sign); err != nil {
+		return nil, err
+	}
+
+	mirror.cookieJar = newCookieJar(target.CookieJar)
+
+	if mirror.idMapper, err = newIDMapper(target.IDMapping); err != nil {
+		return nil, err
+	}
+
+	if mirror.delayed, err = newDelayQueue(delayDir(config), targetURL, target); err != nil {
+		return nil, err
+	}
+
+	if len(target.Members) > 0 {
+		if target.DNS.ReResolve > 0 {
+			return nil, fmt.Errorf("re-resolving is not supported for target groups")
+		}
+
+		members := make([]*member, len(target.Members))
+
+		for i, memberURL := range target.Members {
+			parsedURL, err := url.Parse(memberURL)
+			if err != nil {
+				return nil, err
+			}
+
+			client, err := newHTTPClient(clientProfile, parsedURL.Scheme, overrides)
+			if err != nil {
+				return nil, err
+			}
+
+			members[i] = &member{url: memberURL, client: client}
+		}
+
+		mirror.group, err = newGroup(members, target.Balance, target.HashKey, target.EjectAfter, config.HealthCheckPath, mirror.healthCheckInterval)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		parsedURL, err := url.Parse(targetURL)
+		if err != nil {
+			return nil, err
+		}
+
+		mirror.netClient, err = newHTTPClient(clientProfile, parsedURL.Scheme, overrides)
+		if err != nil {
+			return nil, err
+		}
+
+		if target.DNS.ReResolve > 0 {
+			mirror.resolver, err = newResolver(parsedURL, target.DNS, func(resolved map[string]string) (*http.Client, error) {
+				// The static overrides still apply, also to the host of the target
+				merged := make(map[string]string, len(resolved)+len(overrides))
+				for addr, override := range resolved {
+					merged[addr] = override
+				}
+
+				for addr, override := range overrides {
+					merged[addr] = override
+				}
+
+				return newHTTPClient(clientProfile, parsedURL.Scheme, merged)
+			})
+			if err != nil {
+				return nil, err
+			}
+
+			go mirror.resolver.run(mirror.doneCh)
+		}
 	}
 
 	settings := gobreaker.Settings{
@@ -63,60 +257,353 @@ func NewMirror(targetURL string, config *config.Config, failureCh chan<- string,
 		Timeout:     retryAfter, // When open retry after 60 seconds
 	}
 
-	if persistent {
+	if target.Persistent {
 		settings.OnStateChange = PersistentStatusHandler(mirror)
 	} else {
 		settings.OnStateChange = RemovingStatusHandler(mirror)
 	}
 
-	breaker := gobreaker.NewCircuitBreaker(settings)
+	if mirror.ramp != nil {
+		stateHandler := settings.OnStateChange
+		settings.OnStateChange = func(name string, from, to gobreaker.State) {
+			if to == gobreaker.StateOpen {
+				mirror.ramp.abort("an open circuit breaker")
+			}
+
+			stateHandler(name, from, to)
+		}
+	}
+
+	mirror.settings = settings
+	mirror.breaker = gobreaker.NewCircuitBreaker(settings)
+
+	mirror.startLimits(target.TTL, target.MaxRequests)
 
-	mirror.breaker = breaker
+	return mirror, nil
+}
 
-	return mirror
+// Close stops all background work of the mirror, it is called when the mirror is removed.
+func (m *Mirror) Close() {
+	m.closeOnce.Do(func() {
+		m.stopLimits()
+		close(m.doneCh)
+	})
 }
 
 func (m *Mirror) Reflect(req *Request) {
+	if m.isQuarantined() {
+		// Nothing is sent, but the epoch needs to be completed to keep the send queue in sync
+		m.skip(req)
+		return
+	}
+
+	m.Lock()
+	skip := m.paused && m.pauseMode == PauseSkip
+	m.Unlock()
+
+	if skip || (m.mainConditions != nil && !m.mainConditions.allow(req.main)) || (m.ramp != nil && !m.ramp.sample()) || (m.rateLimiter != nil && !m.rateLimiter.allow(req)) || !m.takeQuota() {
+		m.skip(req)
+		return
+	}
+
+	// Only requests that are sent count against the request limit
+	if !m.takeRequestBudget() {
+		// Waiting to be removed
+		m.skip(req)
+		return
+	}
+
+	if m.delayed != nil {
+		// Delayed requests are sent from the backlog, they do not take part in the ordering of the send queue
+		m.delayed.enqueue(req, time.Now(), m.targetURL)
+		m.sendQueue.ExecutionCompleted(req)
+
+		return
+	}
+
 	m.sendQueue.AddToQueue(req, m.targetURL)
 	// Attempt sending the next items
 	m.tryExecuteNext()
 }
 
+// Completes the epoch of a request that is not sent to this mirror, so the requests queued behind it are sent
+func (m *Mirror) skip(req *Request) {
+	m.sendQueue.ExecutionCompleted(req)
+	m.tryExecuteNext()
+}
+
 func (m *Mirror) tryExecuteNext() {
+	if m.isPaused() {
+		// Requests stay queued until the mirror is resumed
+		return
+	}
+
 	for _, r := range m.sendQueue.NextExecuteItems() {
 		go m.executeRequest(r)
 	}
 }
 
+func (m *Mirror) currentBreaker() *gobreaker.CircuitBreaker {
+	m.Lock()
+	defer m.Unlock()
+
+	return m.breaker
+}
+
 func (m *Mirror) executeRequest(req *Request) {
-	m.breaker.Execute(func() (interface{}, error) { //nolint:errcheck
-		url := fmt.Sprintf("%s%s", m.targetURL, req.originalRequest.RequestURI)
+	m.deliver(m.prepared(req))
 
-		newRequest, err := http.NewRequest(req.originalRequest.Method, url, bytes.NewReader(req.body)) //nolint:noctx
+	m.sendQueue.ExecutionCompleted(req)
+	m.tryExecuteNext()
+}
+
+// Sends the request to the endpoints of the mirror, through the rate limit and circuit breaker.
+func (m *Mirror) deliver(req *Request) {
+	if m.rateLimiter != nil {
+		// The request keeps its place in the send queue while it is delayed
+		if wait := m.rateLimiter.delay(req); wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-m.doneCh:
+				return
+			}
+		}
+	}
+
+	outcome, err := m.currentBreaker().Execute(func() (interface{}, error) {
+		endpoints, err := m.endpoints(req)
 		if err != nil {
 			return nil, err
 		}
 
-		newRequest.Header = req.originalRequest.Header
+		var (
+			result []int
+			errs   []error
+		)
+
+		// When fanning out every address gets the request, also when an earlier one failed
+		for _, ep := range endpoints {
+			statusCode, err := m.sendCopies(req, ep)
+
+			m.completedEndpoint(ep, statusCode, err)
+
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+
+			result = append(result, statusCode)
+		}
+
+		return result, errors.Join(errs...)
+	})
+
+	if m.ramp != nil {
+		m.ramp.record(err != nil || hasServerError(outcome))
+	}
+}
+
+// Records the outcome for the member of the group the endpoint belongs to, if any
+func (m *Mirror) completedEndpoint(ep endpoint, statusCode int, err error) {
+	if ep.member != nil && m.group.completed(ep.member, statusCode, err) {
+		go m.group.probe(ep.member, m.doneCh)
+	}
+}
+
+// An endpoint is a base URL together with the client to send requests to it
+type endpoint struct {
+	url    string
+	client *http.Client
+	member *member
+	// Index of the copy when the request is amplified, 0 otherwise
+	copy int
+}
 
-		response, err := m.netClient.Do(newRequest)
+// The endpoints to send a request to. This is a single endpoint, except when fanning out over the resolved addresses.
+func (m *Mirror) endpoints(req *Request) ([]endpoint, error) {
+	if m.group != nil {
+		picked, err := m.group.pick(req)
 		if err != nil {
-			log.Printf("Error reading response: %v", err)
 			return nil, err
 		}
-		defer response.Body.Close()
-		// Drain the body, but discard it, to make sure connection can be reused
-		return io.Copy(ioutil.Discard, response.Body)
-	})
 
-	m.sendQueue.ExecutionCompleted(req)
-	m.tryExecuteNext()
+		return []endpoint{{url: picked.url, client: picked.client, member: picked}}, nil
+	}
+
+	if m.resolver != nil {
+		if clients := m.resolver.selectClients(); len(clients) > 0 {
+			endpoints := make([]endpoint, len(clients))
+			for i, client := range clients {
+				endpoints[i] = endpoint{url: m.targetURL, client: client}
+			}
+
+			return endpoints, nil
+		}
+	}
+
+	return []endpoint{{url: m.targetURL, client: m.netClient}}, nil
+}
+
+// Send the request, retrying it according to the retry policy. Retries happen while the request holds its place
+// in the send queue, so ordering is kept. Only the final outcome counts for the circuit breaker.
+func (m *Mirror) sendWithRetries(req *Request, ep endpoint) (int, error) {
+	attempts := m.retry.attempts(req.originalRequest.Method)
+
+	for attempt := 1; ; attempt++ {
+		statusCode, err := m.send(req, ep)
+
+		retryable := err != nil || m.retry.retryStatus(statusCode)
+		if !retryable || attempt >= attempts {
+			return statusCode, err
+		}
+
+		backoff := m.retry.backoffFor(attempt)
+		log.Printf("Retrying request to %s in %s (attempt %d of %d)", ep.url, backoff, attempt+1, attempts)
+
+		select {
+		case <-m.doneCh:
+			return statusCode, err
+		case <-time.After(backoff):
+		}
+	}
+}
+
+// Builds the request for the endpoint, with all changes for the target applied.
+func (m *Mirror) newRequest(req *Request, ep endpoint) (*http.Request, error) {
+	requestURI, body := req.originalRequest.RequestURI, req.body
+	if m.idMapper != nil {
+		requestURI, body = m.idMapper.rewrite(requestURI, req.originalRequest.Header.Get("Content-Type"), body)
+	}
+
+	url := fmt.Sprintf("%s%s", ep.url, requestURI)
+
+	newRequest, err := http.NewRequest(req.originalRequest.Method, url, bytes.NewReader(body)) //nolint:noctx
+	if err != nil {
+		return nil, err
+	}
+
+	// Cloned, so headers can be added per mirror without affecting the other mirrors
+	newRequest.Header = req.originalRequest.Header.Clone()
+
+	if ep.copy > 0 {
+		newRequest.Header.Set(m.amplifier.header, strconv.Itoa(ep.copy))
+	}
+
+	if m.mainConditions != nil {
+		m.mainConditions.addHeaders(newRequest.Header, req.main)
+	}
+
+	if m.hopHeader != "" {
+		// Marks the request as mirrored, so it is not mirrored again when it passes a traffic mirror
+		newRequest.Header.Set(m.hopHeader, m.hopID)
+	}
+
+	if m.cookieJar != nil {
+		m.cookieJar.rewrite(newRequest.Header)
+	}
+
+	// Signed last, the signature has to cover the request as the target receives it
+	if m.signer != nil {
+		if err := m.signer.sign(newRequest, body); err != nil {
+			return nil, err
+		}
+	}
+
+	return newRequest, nil
+}
+
+func (m *Mirror) send(req *Request, ep endpoint) (int, error) {
+	newRequest, err := m.newRequest(req, ep)
+	if err != nil {
+		return 0, err
+	}
+
+	response, err := ep.client.Do(newRequest)
+	if err != nil {
+		log.Printf("Error reading response: %v", err)
+		return 0, err
+	}
+	defer response.Body.Close()
+
+	if m.cookieJar != nil {
+		m.cookieJar.record(req, response)
+	}
+
+	if m.idMapper != nil && m.idMapper.wantsResponse(req) {
+		responseBody, err := io.ReadAll(io.LimitReader(response.Body, MaxCaptureBody))
+		if err != nil {
+			return response.StatusCode, err
+		}
+
+		m.idMapper.record(req, response, responseBody)
+	}
+
+	// Drain the body, but discard it, to make sure connection can be reused
+	_, err = io.Copy(ioutil.Discard, response.Body)
+
+	return response.StatusCode, err
+}
+
+// Returns a copy of the request with the redaction rules and credentials of the target applied, the original request
+// is shared by all mirrors.
+func (m *Mirror) prepared(req *Request) *Request {
+	if m.redactor == nil && m.credentials == nil {
+		return req
+	}
+
+	prepared := *req
+	prepared.originalRequest = req.originalRequest.Clone(req.originalRequest.Context())
+
+	if m.redactor != nil {
+		prepared.originalRequest.RequestURI = m.redactor.RequestURI(req.originalRequest.RequestURI)
+		prepared.originalRequest.URL.RawQuery = m.redactor.Query(req.originalRequest.URL.RawQuery)
+		prepared.body = m.redactor.Body(req.originalRequest.Header.Get("Content-Type"), req.originalRequest.Header.Get("Content-Encoding"), req.body)
+
+		m.redactor.Header(prepared.originalRequest.Header)
+	}
+
+	// Applied after redaction, re-minting needs the original credentials and the new ones should not be redacted
+	if m.credentials != nil {
+		m.credentials.apply(prepared.originalRequest.Header, req.originalRequest.Header.Get(m.credentials.header))
+	}
+
+	return &prepared
+}
+
+// The value of the hop header, identifying this traffic mirror
+func hopID(config *config.Config) string {
+	if config.HopID != "" {
+		return config.HopID
+	}
+
+	if hostname, err := os.Hostname(); err == nil {
+		return hostname
+	}
+
+	return "trafficmirror"
 }
 
 func (m *Mirror) GetStatus() *MirrorStatus {
 	var state MirrorState
 
-	switch m.breaker.State() {
+	m.Lock()
+	breaker := m.breaker
+	quarantined := !m.quarantinedSince.IsZero()
+	failingSince := m.firstFailureTime
+	remainingRequests := m.remainingRequests
+	expiresAt := m.expiresAt
+	paused := m.paused
+	quotaResetsAt := m.quotaResetsAt
+	webSockets := m.webSockets
+	webSocketMismatches := m.webSocketMismatches
+	m.Unlock()
+
+	if !quotaResetsAt.After(time.Now()) {
+		// The window already passed, the next request resets the quota
+		quotaResetsAt = time.Time{}
+	}
+
+	switch breaker.State() {
 	case gobreaker.StateOpen:
 		state = StateFailing
 	case gobreaker.StateHalfOpen:
@@ -127,13 +614,47 @@ func (m *Mirror) GetStatus() *MirrorStatus {
 		state = StateUnkown
 	}
 
+	if quarantined {
+		state = StateQuarantined
+	}
+
 	epoch, queued := m.sendQueue.QueueStatus()
 
-	return &MirrorStatus{
-		State:          state,
-		FailingSince:   m.firstFailureTime,
-		URL:            m.targetURL,
-		QueuedRequests: queued,
-		Epoch:          epoch,
+	status := &MirrorStatus{
+		State:               state,
+		FailingSince:        failingSince,
+		URL:                 m.targetURL,
+		QueuedRequests:      queued,
+		Epoch:               epoch,
+		Labels:              m.target.Labels,
+		Metadata:            m.target.Metadata,
+		ExpiresAt:           expiresAt,
+		Paused:              paused || !quotaResetsAt.IsZero(),
+		RemainingRequests:   remainingRequests,
+		QuotaResetsAt:       quotaResetsAt,
+		WebSockets:          webSockets,
+		WebSocketMismatches: webSocketMismatches,
 	}
+
+	if m.ramp != nil {
+		status.Ramp = m.ramp.status()
+	}
+
+	if m.delayed != nil {
+		status.DelayedRequests = m.delayed.len()
+	}
+
+	if m.cookieJar != nil {
+		status.MappedCookies = m.cookieJar.mappings.len()
+	}
+
+	if m.idMapper != nil {
+		status.MappedIDs = m.idMapper.mappings.len()
+	}
+
+	if m.group != nil {
+		status.Members = m.group.status()
+	}
+
+	return status
 }
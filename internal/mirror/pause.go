@@ -0,0 +1,113 @@
+package mirror
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+const (
+	PauseBuffer = "buffer"
+	PauseSkip   = "skip"
+)
+
+func parsePauseMode(mode string) (string, error) {
+	switch strings.ToLower(mode) {
+	case "", PauseBuffer:
+		return PauseBuffer, nil
+	case PauseSkip:
+		return PauseSkip, nil
+	default:
+		return "", fmt.Errorf("invalid pause mode '%s', expected '%s' or '%s'", mode, PauseBuffer, PauseSkip)
+	}
+}
+
+func (m *Mirror) isPaused() bool {
+	m.Lock()
+	defer m.Unlock()
+
+	return m.paused
+}
+
+// Pause stops sending requests to the target, the circuit breaker state and counters are kept.
+func (m *Mirror) Pause() {
+	m.Lock()
+	defer m.Unlock()
+
+	if !m.paused {
+		log.Printf("Pausing target %s, requests are %s.", m.targetURL, map[string]string{PauseBuffer: "buffered", PauseSkip: "skipped"}[m.pauseMode])
+		m.paused = true
+	}
+}
+
+// Resume continues sending requests to the target, starting with the buffered requests.
+func (m *Mirror) Resume() {
+	m.Lock()
+	wasPaused := m.paused
+	m.paused = false
+	m.Unlock()
+
+	if wasPaused {
+		log.Printf("Resuming target %s.", m.targetURL)
+		m.tryExecuteNext()
+	}
+}
+
+// Pause pauses the targets with the given keys.
+func (r *Reflector) Pause(keys []string) error {
+	return r.forMirrors(keys, (*Mirror).Pause)
+}
+
+// Resume resumes the targets with the given keys.
+func (r *Reflector) Resume(keys []string) error {
+	return r.forMirrors(keys, (*Mirror).Resume)
+}
+
+func (r *Reflector) forMirrors(keys []string, f func(*Mirror)) error {
+	r.RLock()
+	defer r.RUnlock()
+
+	for _, key := range keys {
+		if _, ok := r.mirrors[key]; !ok {
+			return fmt.Errorf("unknown target '%s'", key)
+		}
+	}
+
+	for _, key := range keys {
+		f(r.mirrors[key])
+	}
+
+	return nil
+}
+
+// SetMirroringEnabled is the global kill switch. When disabled no requests are mirrored at all and all queued
+// requests are dropped, but the targets are kept.
+func (r *Reflector) SetMirroringEnabled(enabled bool) {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.mirroringDisabled == !enabled {
+		return
+	}
+
+	r.mirroringDisabled = !enabled
+
+	if enabled {
+		log.Printf("Mirroring enabled.")
+		return
+	}
+
+	log.Printf("Mirroring disabled, dropping all queued requests.")
+
+	for _, mirror := range r.mirrors {
+		mirror.sendQueue.DropQueued()
+		mirror.discardDelayed()
+	}
+}
+
+func (r *Reflector) MirroringEnabled() bool {
+	r.RLock()
+	defer r.RUnlock()
+
+	return !r.mirroringDisabled
+}
@@ -0,0 +1,91 @@
+package mirror
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rb3ckers/trafficmirror/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func mkCountingServer(requests *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(requests, 1)
+	}))
+}
+
+func mkGetRequest(epoch uint64) *Request {
+	req := mkRequest(epoch, []uint64{})
+	req.originalRequest = httptest.NewRequest(http.MethodGet, "/", nil)
+
+	return req
+}
+
+func TestPausedMirrorBuffersRequests(t *testing.T) {
+	var requests int32
+
+	server := mkCountingServer(&requests)
+	defer server.Close()
+
+	r := NewReflector(config.Default())
+	assert.NoError(t, r.AddTargets([]*config.Target{{URL: server.URL}}))
+	assert.NoError(t, r.Pause([]string{server.URL}))
+
+	r.sendToMirrors(mkGetRequest(1))
+	r.sendToMirrors(mkGetRequest(2))
+
+	status := r.ListMirrors()[0]
+	assert.True(t, status.Paused)
+	assert.Equal(t, 2, status.QueuedRequests)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&requests))
+
+	assert.NoError(t, r.Resume([]string{server.URL}))
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&requests) == 2 }, time.Second, 10*time.Millisecond)
+}
+
+func TestPausedMirrorSkipsRequests(t *testing.T) {
+	var requests int32
+
+	server := mkCountingServer(&requests)
+	defer server.Close()
+
+	r := NewReflector(config.Default())
+	assert.NoError(t, r.AddTargets([]*config.Target{{URL: server.URL, PauseMode: PauseSkip}}))
+	assert.NoError(t, r.Pause([]string{server.URL}))
+
+	r.sendToMirrors(mkGetRequest(1))
+	assert.NoError(t, r.Resume([]string{server.URL}))
+	r.sendToMirrors(mkGetRequest(2))
+
+	assert.Eventually(t, func() bool { return r.ListMirrors()[0].Epoch == 2 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+}
+
+func TestPauseUnknownTarget(t *testing.T) {
+	r := NewReflector(config.Default())
+	assert.Error(t, r.Pause([]string{"http://unknown:8080"}))
+}
+
+func TestKillSwitchStopsMirroring(t *testing.T) {
+	var requests int32
+
+	server := mkCountingServer(&requests)
+	defer server.Close()
+
+	r := NewReflector(config.Default())
+	assert.NoError(t, r.AddTargets([]*config.Target{{URL: server.URL}}))
+
+	r.SetMirroringEnabled(false)
+	assert.False(t, r.MirroringEnabled())
+
+	r.sendToMirrors(mkGetRequest(1))
+	assert.Equal(t, uint64(1), r.ListMirrors()[0].Epoch)
+
+	r.SetMirroringEnabled(true)
+	r.sendToMirrors(mkGetRequest(2))
+
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&requests) == 1 }, time.Second, 10*time.Millisecond)
+}
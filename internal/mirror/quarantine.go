@@ -0,0 +1,100 @@
+package mirror
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/sony/gobreaker"
+)
+
+func (m *Mirror) isQuarantined() bool {
+	m.Lock()
+	defer m.Unlock()
+
+	return !m.quarantinedSince.IsZero()
+}
+
+// Puts the mirror in quarantine: it stays listed, but does not receive traffic until the health check succeeds.
+// This expects the lock to be held
+func (m *Mirror) startQuarantine() {
+	if !m.quarantinedSince.IsZero() {
+		return
+	}
+
+	log.Printf("Quarantining target %s, probing %s every %s.", m.targetURL, m.healthCheckURL, m.healthCheckInterval)
+
+	m.quarantinedSince = time.Now()
+
+	go m.probeQuarantined(m.quarantinedSince)
+}
+
+func (m *Mirror) probeQuarantined(since time.Time) {
+	ticker := time.NewTicker(m.healthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.doneCh:
+			return
+		case <-ticker.C:
+			if m.isHealthy() {
+				m.readmit()
+				return
+			}
+
+			if m.maxQuarantine > 0 && time.Since(since) > m.maxQuarantine {
+				log.Printf("%s has been quarantined for more than %s.", m.targetURL, m.maxQuarantine)
+				m.fail()
+
+				return
+			}
+		}
+	}
+}
+
+// Signals the reflector to remove the persistently failing mirror. Like expire, this does not block: the breaker
+// reports failures while the reflector waits for the lock of the mirror.
+func (m *Mirror) fail() {
+	m.failOnce.Do(func() {
+		go func() {
+			select {
+			case m.failureCh <- m.targetURL:
+			case <-m.doneCh:
+			}
+		}()
+	})
+}
+
+func (m *Mirror) isHealthy() bool {
+	if m.group != nil {
+		return m.group.anyHealthy()
+	}
+
+	return checkHealth(m.netClient, m.healthCheckURL)
+}
+
+func checkHealth(client *http.Client, url string) bool {
+	response, err := client.Get(url) //nolint:noctx
+	if err != nil {
+		return false
+	}
+	defer response.Body.Close()
+	// Drain the body, but discard it, to make sure connection can be reused
+	io.Copy(io.Discard, response.Body) //nolint:errcheck
+
+	return response.StatusCode >= http.StatusOK && response.StatusCode < http.StatusMultipleChoices
+}
+
+// Lets the mirror rejoin with a fresh circuit breaker, the old one is still open.
+func (m *Mirror) readmit() {
+	m.Lock()
+	defer m.Unlock()
+
+	m.quarantinedSince = time.Time{}
+	m.firstFailureTime = time.Time{}
+	m.breaker = gobreaker.NewCircuitBreaker(m.settings)
+
+	log.Printf("Target %s is healthy, resuming mirroring.", m.targetURL)
+}
@@ -0,0 +1,138 @@
+package mirror
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rb3ckers/trafficmirror/internal/config"
+	"github.com/sony/gobreaker"
+	"github.com/stretchr/testify/assert"
+)
+
+func mkQuarantinedMirror(t *testing.T, url string, failureCh chan string) *Mirror {
+	cfg := config.Default()
+	cfg.Quarantine = true
+
+	m, err := NewMirror(&config.Target{URL: url}, cfg, failureCh, make(chan string), MakeSendQueue(5))
+	assert.NoError(t, err)
+
+	m.healthCheckInterval = 10 * time.Millisecond
+
+	m.Lock()
+	m.startQuarantine()
+	m.Unlock()
+
+	return m
+}
+
+func TestQuarantineRequiresPositiveHealthCheckInterval(t *testing.T) {
+	cfg := config.Default()
+	cfg.Quarantine = true
+	cfg.HealthCheckInterval = 0
+
+	_, err := NewMirror(&config.Target{URL: "http://shadow:8080"}, cfg, make(chan string), make(chan string), MakeSendQueue(5))
+	assert.Error(t, err)
+}
+
+func TestQuarantinedMirrorSkipsRequests(t *testing.T) {
+	var requests int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	m := mkQuarantinedMirror(t, server.URL, make(chan string))
+	defer m.Close()
+
+	m.Reflect(mkRequest(1, []uint64{}))
+
+	epoch, queued := m.sendQueue.QueueStatus()
+	assert.Equal(t, uint64(1), epoch)
+	assert.Equal(t, 0, queued)
+	assert.Equal(t, StateQuarantined, m.GetStatus().State)
+}
+
+func TestQuarantinedMirrorRejoinsWhenHealthy(t *testing.T) {
+	var healthy int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&healthy) == 1 {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	m := mkQuarantinedMirror(t, server.URL, make(chan string))
+	defer m.Close()
+
+	time.Sleep(50 * time.Millisecond)
+	assert.True(t, m.isQuarantined())
+
+	atomic.StoreInt32(&healthy, 1)
+
+	assert.Eventually(t, func() bool { return !m.isQuarantined() }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, StateAlive, m.GetStatus().State)
+}
+
+func TestQuarantinedMirrorRemovedAfterMaxQuarantine(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	failureCh := make(chan string, 1)
+
+	cfg := config.Default()
+	cfg.Quarantine = true
+
+	m, err := NewMirror(&config.Target{URL: server.URL}, cfg, failureCh, make(chan string), MakeSendQueue(5))
+	assert.NoError(t, err)
+	defer m.Close()
+
+	m.healthCheckInterval = 10 * time.Millisecond
+	m.maxQuarantine = 30 * time.Millisecond
+
+	m.Lock()
+	m.startQuarantine()
+	m.Unlock()
+
+	select {
+	case url := <-failureCh:
+		assert.Equal(t, server.URL, url)
+	case <-time.After(time.Second):
+		assert.Fail(t, "Quarantined mirror was not removed")
+	}
+}
+
+func TestPersistentFailureDoesNotBlockWhileHoldingTheLock(t *testing.T) {
+	failureCh := make(chan string)
+
+	m, err := NewMirror(&config.Target{URL: "http://localhost:1"}, config.Default(), failureCh, make(chan string), MakeSendQueue(5))
+	assert.NoError(t, err)
+	defer m.Close()
+
+	m.firstFailureTime = time.Now().Add(-time.Hour)
+
+	done := make(chan struct{})
+	go func() {
+		RemovingStatusHandler(m)("target", gobreaker.StateHalfOpen, gobreaker.StateOpen)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the status handler blocks until the reflector receives the failure")
+	}
+
+	// The reflector can still check the mirror before it receives the failure
+	assert.False(t, m.isQuarantined())
+	assert.Equal(t, "http://localhost:1", <-failureCh)
+}
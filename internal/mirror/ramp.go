@@ -0,0 +1,169 @@
+package mirror
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rb3ckers/trafficmirror/internal/config"
+)
+
+const (
+	RampHold     = "hold"
+	RampRollback = "rollback"
+)
+
+// The error rate is only judged after this many requests, so a single early failure does not abort the ramp
+const minRampSamples = 20
+
+// RampStatus describes a ramp that is still in progress or was aborted.
+type RampStatus struct {
+	Percentage float64
+	Aborted    bool
+	Reason     string
+}
+
+// Sends a linearly growing percentage of the requests to the mirror, until all requests are sent.
+type ramp struct {
+	sync.Mutex
+	targetURL    string
+	from         float64
+	duration     time.Duration
+	rollback     bool
+	maxErrorRate float64
+	start        time.Time
+	aborted      bool
+	// The percentage the ramp stays at after it was aborted
+	abortedAt float64
+	reason    string
+	sent      int
+	failed    int
+	now       func() time.Time
+	random    func() float64
+}
+
+func newRamp(targetURL string, cfg config.Ramp) (*ramp, error) {
+	if cfg.Duration <= 0 {
+		return nil, nil
+	}
+
+	if cfg.From < 0 || cfg.From > 100 {
+		return nil, fmt.Errorf("ramp start percentage must be between 0 and 100")
+	}
+
+	r := &ramp{
+		targetURL:    targetURL,
+		from:         cfg.From,
+		duration:     cfg.Duration,
+		maxErrorRate: cfg.MaxErrorRate,
+		start:        time.Now(),
+		now:          time.Now,
+		random:       rand.Float64, //nolint:gosec
+	}
+
+	switch strings.ToLower(cfg.OnFailure) {
+	case "", RampHold:
+	case RampRollback:
+		r.rollback = true
+	default:
+		return nil, fmt.Errorf("invalid ramp failure mode '%s', expected '%s' or '%s'", cfg.OnFailure, RampHold, RampRollback)
+	}
+
+	return r, nil
+}
+
+// The lock must be held
+func (r *ramp) percentage() float64 {
+	if r.aborted {
+		return r.abortedAt
+	}
+
+	elapsed := r.now().Sub(r.start)
+	if elapsed >= r.duration {
+		return 100 //nolint:gomnd
+	}
+
+	return r.from + (100-r.from)*elapsed.Seconds()/r.duration.Seconds() //nolint:gomnd
+}
+
+// Returns whether the request is part of the percentage that is sent.
+func (r *ramp) sample() bool {
+	r.Lock()
+	defer r.Unlock()
+
+	return r.random()*100 < r.percentage() //nolint:gomnd
+}
+
+// Records the outcome of a sent request, aborting the ramp when the error rate gets too high.
+func (r *ramp) record(failed bool) {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.aborted || r.maxErrorRate <= 0 {
+		return
+	}
+
+	r.sent++
+
+	if failed {
+		r.failed++
+	}
+
+	if r.sent >= minRampSamples {
+		if errorRate := float64(r.failed) / float64(r.sent) * 100; errorRate > r.maxErrorRate { //nolint:gomnd
+			r.abortLocked(fmt.Sprintf("error rate of %.1f%%", errorRate))
+		}
+	}
+}
+
+func (r *ramp) abort(reason string) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.abortLocked(reason)
+}
+
+func (r *ramp) abortLocked(reason string) {
+	current := r.percentage()
+	if r.aborted || current >= 100 {
+		return
+	}
+
+	r.aborted = true
+	r.reason = reason
+	r.abortedAt = current
+
+	if r.rollback {
+		r.abortedAt = r.from
+	}
+
+	log.Printf("Ramp of target %s aborted because of %s, staying at %.1f%% of the requests.", r.targetURL, reason, r.abortedAt)
+}
+
+// Returns nil once the ramp completed.
+func (r *ramp) status() *RampStatus {
+	r.Lock()
+	defer r.Unlock()
+
+	current := r.percentage()
+	if current >= 100 {
+		return nil
+	}
+
+	return &RampStatus{Percentage: current, Aborted: r.aborted, Reason: r.reason}
+}
+
+func hasServerError(outcome interface{}) bool {
+	statusCodes, _ := outcome.([]int)
+	for _, statusCode := range statusCodes {
+		if statusCode >= http.StatusInternalServerError {
+			return true
+		}
+	}
+
+	return false
+}
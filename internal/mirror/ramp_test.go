@@ -0,0 +1,107 @@
+package mirror
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rb3ckers/trafficmirror/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func mkRamp(t *testing.T, cfg config.Ramp, now *time.Time) *ramp {
+	r, err := newRamp("http://shadow:8080", cfg)
+	assert.NoError(t, err)
+
+	r.start = *now
+	r.now = func() time.Time { return *now }
+
+	return r
+}
+
+func TestRampIncreasesPercentage(t *testing.T) {
+	now := time.Now()
+	r := mkRamp(t, config.Ramp{Duration: 10 * time.Minute, From: 10}, &now)
+
+	assert.Equal(t, 10.0, r.status().Percentage)
+
+	now = now.Add(5 * time.Minute)
+	assert.Equal(t, 55.0, r.status().Percentage)
+
+	now = now.Add(5 * time.Minute)
+	assert.Nil(t, r.status())
+
+	// Aborting a completed ramp does nothing
+	r.abort("an open circuit breaker")
+	assert.Nil(t, r.status())
+}
+
+func TestRampHoldsOnFailure(t *testing.T) {
+	now := time.Now()
+	r := mkRamp(t, config.Ramp{Duration: 10 * time.Minute, From: 10}, &now)
+
+	now = now.Add(5 * time.Minute)
+	r.abort("an open circuit breaker")
+
+	now = now.Add(time.Hour)
+	assert.Equal(t, &RampStatus{Percentage: 55, Aborted: true, Reason: "an open circuit breaker"}, r.status())
+}
+
+func TestRampRollsBackOnErrorRate(t *testing.T) {
+	now := time.Now()
+	r := mkRamp(t, config.Ramp{Duration: 10 * time.Minute, From: 10, OnFailure: RampRollback, MaxErrorRate: 10}, &now)
+
+	now = now.Add(5 * time.Minute)
+
+	for i := 0; i < minRampSamples-2; i++ {
+		r.record(false)
+	}
+
+	r.record(true)
+	r.record(true)
+	assert.False(t, r.status().Aborted)
+
+	r.record(true)
+	assert.True(t, r.status().Aborted)
+	assert.Equal(t, 10.0, r.status().Percentage)
+}
+
+func TestRampSamplesRequests(t *testing.T) {
+	var requests int32
+
+	server := mkCountingServer(&requests)
+	defer server.Close()
+
+	r := NewReflector(config.Default())
+	assert.NoError(t, r.AddTargets([]*config.Target{{URL: server.URL, Ramp: config.Ramp{Duration: time.Hour}}}))
+
+	for epoch := uint64(1); epoch <= 10; epoch++ {
+		r.sendToMirrors(mkGetRequest(epoch))
+	}
+
+	assert.Eventually(t, func() bool { return r.ListMirrors()[0].Epoch == 10 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&requests))
+	assert.NotNil(t, r.ListMirrors()[0].Ramp)
+}
+
+func TestRampAbortsWhenBreakerOpens(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.Close()
+
+	m, err := NewMirror(&config.Target{URL: server.URL, Ramp: config.Ramp{Duration: time.Hour, From: 50}}, config.Default(), make(chan string), make(chan string), MakeSendQueue(20))
+	assert.NoError(t, err)
+
+	defer m.Close()
+
+	// Sample every request
+	m.ramp.random = func() float64 { return 0 }
+
+	for epoch := uint64(1); epoch <= 10; epoch++ {
+		m.Reflect(mkGetRequest(epoch))
+	}
+
+	assert.Eventually(t, func() bool { return m.ramp.status() != nil && m.ramp.status().Aborted }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, "an open circuit breaker", m.ramp.status().Reason)
+}
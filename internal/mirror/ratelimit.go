@@ -0,0 +1,250 @@
+package mirror
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rb3ckers/trafficmirror/internal/config"
+)
+
+const (
+	RateLimitDelay = "delay"
+	RateLimitDrop  = "drop"
+)
+
+// A token bucket that refills at a fixed rate up to its burst size. Taking more tokens than available puts the bucket
+// in debt, so requests larger than the burst still get through, just later.
+type tokenBucket struct {
+	sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+}
+
+func newTokenBucket(rate float64) *tokenBucket {
+	// Allow a second worth of tokens at once, but at least one
+	burst := math.Max(rate, 1)
+
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, last: time.Now(), now: time.Now}
+}
+
+func (b *tokenBucket) refill() {
+	now := b.now()
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+// Takes the tokens and returns how long to wait before they are actually available.
+func (b *tokenBucket) reserve(n float64) time.Duration {
+	b.Lock()
+	defer b.Unlock()
+
+	b.refill()
+	b.tokens -= n
+
+	if b.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// Takes the tokens only when they are available now, or when the bucket is full for takes larger than the burst.
+func (b *tokenBucket) tryTake(n float64) bool {
+	b.Lock()
+	defer b.Unlock()
+
+	b.refill()
+
+	if b.tokens < math.Min(n, b.burst) {
+		return false
+	}
+
+	b.tokens -= n
+
+	return true
+}
+
+// Returns tokens that were taken but not used, the bucket never holds more than its burst.
+func (b *tokenBucket) giveBack(n float64) {
+	b.Lock()
+	defer b.Unlock()
+
+	b.refill()
+	b.tokens = math.Min(b.burst, b.tokens+n)
+}
+
+// Limits the requests and bytes per second sent to a mirror
+type rateLimiter struct {
+	drop     bool
+	requests *tokenBucket
+	bytes    *tokenBucket
+}
+
+func newRateLimiter(limit config.RateLimit) (*rateLimiter, error) {
+	limiter := &rateLimiter{}
+
+	switch strings.ToLower(limit.Mode) {
+	case "", RateLimitDelay:
+	case RateLimitDrop:
+		limiter.drop = true
+	default:
+		return nil, fmt.Errorf("invalid rate limit mode '%s', expected '%s' or '%s'", limit.Mode, RateLimitDelay, RateLimitDrop)
+	}
+
+	if limit.Requests < 0 || limit.Bytes < 0 {
+		return nil, fmt.Errorf("rate limits can not be negative")
+	}
+
+	if limit.Requests > 0 {
+		limiter.requests = newTokenBucket(limit.Requests)
+	}
+
+	if limit.Bytes > 0 {
+		limiter.bytes = newTokenBucket(float64(limit.Bytes))
+	}
+
+	if limiter.requests == nil && limiter.bytes == nil {
+		return nil, nil
+	}
+
+	return limiter, nil
+}
+
+// Returns false when the request is over the limit and should be dropped.
+func (l *rateLimiter) allow(req *Request) bool {
+	if !l.drop {
+		return true
+	}
+
+	if l.requests != nil && !l.requests.tryTake(1) {
+		return false
+	}
+
+	if l.bytes != nil && !l.bytes.tryTake(float64(len(req.body))) {
+		// Give back the request token, the request is not sent after all
+		if l.requests != nil {
+			l.requests.giveBack(1)
+		}
+
+		return false
+	}
+
+	return true
+}
+
+// Returns how long to delay the request to stay within the limit.
+func (l *rateLimiter) delay(req *Request) time.Duration {
+	if l.drop {
+		return 0
+	}
+
+	var wait time.Duration
+
+	if l.requests != nil {
+		wait = l.requests.reserve(1)
+	}
+
+	if l.bytes != nil {
+		if bytesWait := l.bytes.reserve(float64(len(req.body))); bytesWait > wait {
+			wait = bytesWait
+		}
+	}
+
+	return wait
+}
+
+// Counts the requests in the current hour or day
+type quota struct {
+	limit int
+	used  int
+	start time.Time
+	next  func(time.Time) time.Time
+}
+
+func startOfHour(t time.Time) time.Time {
+	return t.Truncate(time.Hour)
+}
+
+func startOfDay(t time.Time) time.Time {
+	year, month, day := t.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, t.Location())
+}
+
+func newQuotas(limit config.RateLimit) []*quota {
+	var quotas []*quota
+
+	if limit.HourlyQuota > 0 {
+		quotas = append(quotas, &quota{limit: limit.HourlyQuota, start: startOfHour(time.Now()), next: func(t time.Time) time.Time {
+			return startOfHour(t).Add(time.Hour)
+		}})
+	}
+
+	if limit.DailyQuota > 0 {
+		quotas = append(quotas, &quota{limit: limit.DailyQuota, start: startOfDay(time.Now()), next: func(t time.Time) time.Time {
+			return startOfDay(t).AddDate(0, 0, 1)
+		}})
+	}
+
+	return quotas
+}
+
+// Starts a new window when the current one has passed
+func (q *quota) roll(now time.Time) {
+	if !now.Before(q.next(q.start)) {
+		q.start = now
+		q.used = 0
+	}
+}
+
+func (q *quota) exhausted() bool {
+	return q.used >= q.limit
+}
+
+// Takes a request from the quotas. While a quota is used up the target is paused, requests are skipped until the
+// window resets. Returns false when no quota is left.
+func (m *Mirror) takeQuota() bool {
+	if len(m.quotas) == 0 {
+		return true
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	now := time.Now()
+
+	for _, q := range m.quotas {
+		q.roll(now)
+	}
+
+	if !m.quotaResetsAt.IsZero() && !now.Before(m.quotaResetsAt) {
+		log.Printf("Quota of target %s was reset, resuming it.", m.targetURL)
+		m.quotaResetsAt = time.Time{}
+	}
+
+	for _, q := range m.quotas {
+		if q.exhausted() {
+			return false
+		}
+	}
+
+	for _, q := range m.quotas {
+		q.used++
+
+		if next := q.next(q.start); q.exhausted() && next.After(m.quotaResetsAt) {
+			m.quotaResetsAt = next
+		}
+	}
+
+	if !m.quotaResetsAt.IsZero() {
+		log.Printf("Target %s used up its quota, pausing it until %s.", m.targetURL, m.quotaResetsAt.Format(time.RFC3339))
+	}
+
+	return true
+}
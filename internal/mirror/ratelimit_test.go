@@ -0,0 +1,145 @@
+package mirror
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rb3ckers/trafficmirror/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func mkTokenBucket(rate float64, now *time.Time) *tokenBucket {
+	b := newTokenBucket(rate)
+	b.last = *now
+	b.now = func() time.Time { return *now }
+
+	return b
+}
+
+func TestTokenBucketReserve(t *testing.T) {
+	now := time.Now()
+	b := mkTokenBucket(2, &now)
+
+	assert.Equal(t, time.Duration(0), b.reserve(1))
+	assert.Equal(t, time.Duration(0), b.reserve(1))
+	assert.Equal(t, 500*time.Millisecond, b.reserve(1))
+	assert.Equal(t, time.Second, b.reserve(1))
+
+	now = now.Add(time.Second)
+	assert.Equal(t, time.Duration(0), b.reserve(0))
+}
+
+func TestTokenBucketTryTake(t *testing.T) {
+	now := time.Now()
+	b := mkTokenBucket(100, &now)
+
+	// Larger than the burst, but the bucket is full
+	assert.True(t, b.tryTake(150))
+	assert.False(t, b.tryTake(1))
+
+	now = now.Add(time.Second)
+	assert.True(t, b.tryTake(1))
+}
+
+func TestTokenBucketGiveBackClampsToBurst(t *testing.T) {
+	now := time.Now()
+	b := mkTokenBucket(2, &now)
+
+	// The bucket is full, giving back does not grow it beyond the burst
+	b.giveBack(1)
+	assert.True(t, b.tryTake(2))
+	assert.False(t, b.tryTake(1))
+
+	b.giveBack(1)
+	assert.True(t, b.tryTake(1))
+}
+
+func TestRateLimitDropsExcessRequests(t *testing.T) {
+	var requests int32
+
+	server := mkCountingServer(&requests)
+	defer server.Close()
+
+	r := NewReflector(config.Default())
+	assert.NoError(t, r.AddTargets([]*config.Target{{URL: server.URL, RateLimit: config.RateLimit{Requests: 2, Mode: RateLimitDrop}}}))
+
+	for epoch := uint64(1); epoch <= 5; epoch++ {
+		r.sendToMirrors(mkGetRequest(epoch))
+	}
+
+	assert.Eventually(t, func() bool { return r.ListMirrors()[0].Epoch == 5 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&requests))
+}
+
+func TestRateLimitDelaysRequests(t *testing.T) {
+	var requests int32
+
+	server := mkCountingServer(&requests)
+	defer server.Close()
+
+	r := NewReflector(config.Default())
+	assert.NoError(t, r.AddTargets([]*config.Target{{URL: server.URL, RateLimit: config.RateLimit{Requests: 10}}}))
+
+	start := time.Now()
+
+	for epoch := uint64(1); epoch <= 12; epoch++ {
+		r.sendToMirrors(mkGetRequest(epoch))
+	}
+
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&requests) == 12 }, 2*time.Second, 10*time.Millisecond)
+	assert.GreaterOrEqual(t, time.Since(start), 150*time.Millisecond)
+}
+
+func TestQuotaPausesTarget(t *testing.T) {
+	var requests int32
+
+	server := mkCountingServer(&requests)
+	defer server.Close()
+
+	r := NewReflector(config.Default())
+	assert.NoError(t, r.AddTargets([]*config.Target{{URL: server.URL, RateLimit: config.RateLimit{HourlyQuota: 2, DailyQuota: 10}}}))
+
+	for epoch := uint64(1); epoch <= 4; epoch++ {
+		r.sendToMirrors(mkGetRequest(epoch))
+	}
+
+	assert.Eventually(t, func() bool { return r.ListMirrors()[0].Epoch == 4 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&requests))
+
+	status := r.ListMirrors()[0]
+	assert.True(t, status.Paused)
+	assert.Equal(t, startOfHour(time.Now()).Add(time.Hour), status.QuotaResetsAt)
+}
+
+func TestDroppedRequestsDoNotCountAgainstQuota(t *testing.T) {
+	var requests int32
+
+	server := mkCountingServer(&requests)
+	defer server.Close()
+
+	r := NewReflector(config.Default())
+	assert.NoError(t, r.AddTargets([]*config.Target{{URL: server.URL, RateLimit: config.RateLimit{Requests: 2, Mode: RateLimitDrop, HourlyQuota: 3}}}))
+
+	for epoch := uint64(1); epoch <= 5; epoch++ {
+		r.sendToMirrors(mkGetRequest(epoch))
+	}
+
+	assert.Eventually(t, func() bool { return r.ListMirrors()[0].Epoch == 5 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&requests))
+	assert.True(t, r.ListMirrors()[0].QuotaResetsAt.IsZero())
+}
+
+func TestQuotaWindowReset(t *testing.T) {
+	q := newQuotas(config.RateLimit{DailyQuota: 1})[0]
+	q.used = 1
+	assert.True(t, q.exhausted())
+
+	q.roll(time.Now().AddDate(0, 0, 1))
+	assert.False(t, q.exhausted())
+}
+
+func TestInvalidRateLimitMode(t *testing.T) {
+	_, err := newRateLimiter(config.RateLimit{Requests: 1, Mode: "queue"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,53 @@
+package mirror
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rb3ckers/trafficmirror/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedactedBeforeMirroring(t *testing.T) {
+	received := make(chan *http.Request, 1)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		received <- r
+	}))
+	defer server.Close()
+
+	r := NewReflector(config.Default())
+	assert.NoError(t, r.AddTargets([]*config.Target{{URL: server.URL, Redact: config.RedactRules{
+		DropHeaders: []string{"Authorization"},
+		JSONPaths:   []string{"email"},
+		FormFields:  []string{"token"},
+	}}}))
+
+	req := mkRequest(1, []uint64{})
+	req.originalRequest = httptest.NewRequest(http.MethodPost, "/users?token=abc", nil)
+	req.originalRequest.Header.Set("Authorization", "Bearer secret")
+	req.originalRequest.Header.Set("Content-Type", "application/json")
+	req.body = []byte(`{"email":"alice@example.com"}`)
+
+	r.sendToMirrors(req)
+
+	select {
+	case mirrored := <-received:
+		body, _ := io.ReadAll(mirrored.Body)
+		assert.JSONEq(t, `{"email":"REDACTED"}`, string(body))
+		assert.Empty(t, mirrored.Header.Get("Authorization"))
+		assert.Equal(t, "token=REDACTED", mirrored.URL.RawQuery)
+	case <-time.After(time.Second):
+		t.Fatal("request was not mirrored")
+	}
+
+	// The original request, shared with the other mirrors, is untouched
+	assert.Equal(t, "Bearer secret", req.originalRequest.Header.Get("Authorization"))
+	assert.Equal(t, "/users?token=abc", req.originalRequest.RequestURI)
+}
@@ -1,10 +1,13 @@
 package mirror
 
 import (
-	"github.com/rb3ckers/trafficmirror/internal/config"
 	"log"
+	"net/http"
+	"reflect"
 	"sync"
 	"time"
+
+	"github.com/rb3ckers/trafficmirror/internal/config"
 )
 
 type Reflector struct {
@@ -13,10 +16,15 @@ type Reflector struct {
 	IncomingCh        chan *Request
 	DoneCh            chan bool
 	MirrorFailureChan chan string
+	MirrorExpiredChan chan string
 	config            *config.Config
 	// This sendQueue is kept to keep exact state of what epochs were sent by the handler. This is used when we make a
 	// new mirror so we the state of that new mirror is in sync.
 	templateSendQueue *SendQueue
+	// Lease ID to the key of the leased target
+	leases map[string]string
+	// Global kill switch
+	mirroringDisabled bool
 }
 
 func NewReflector(config *config.Config) *Reflector {
@@ -25,8 +33,10 @@ func NewReflector(config *config.Config) *Reflector {
 		IncomingCh:        make(chan *Request),
 		DoneCh:            make(chan bool),
 		MirrorFailureChan: make(chan string),
+		MirrorExpiredChan: make(chan string),
 		config:            config,
 		templateSendQueue: MakeSendQueue(config.MaxQueuedRequests),
+		leases:            make(map[string]string),
 	}
 }
 
@@ -41,6 +51,9 @@ func (r *Reflector) Reflect() {
 		case url := <-r.MirrorFailureChan:
 			log.Printf("Mirror '%s' has persistent failures", url)
 			r.RemoveMirrors([]string{url})
+		case url := <-r.MirrorExpiredChan:
+			log.Printf("Mirror '%s' has reached its time or request limit", url)
+			r.RemoveMirrors([]string{url})
 		case <-r.DoneCh:
 			return
 		}
@@ -68,18 +81,126 @@ func (r *Reflector) sendToMirrors(req *Request) {
 	defer r.RUnlock()
 
 	for _, mirror := range r.mirrors {
-		mirror.Reflect(req)
+		if r.mirroringDisabled || (req.targetFilter != nil && !req.targetFilter.matches(mirror)) {
+			// Keep the send queues in sync
+			mirror.skip(req)
+		} else {
+			mirror.Reflect(req)
+		}
+	}
+}
+
+func (r *Reflector) AddMirrors(urls []string, persistent bool) error {
+	targets := make([]*config.Target, len(urls))
+
+	for i, url := range urls {
+		targets[i] = &config.Target{URL: url, Persistent: persistent}
 	}
+
+	return r.AddTargets(targets)
 }
 
-func (r *Reflector) AddMirrors(urls []string, persistent bool) {
+// AddTargets validates and adds the targets. A target that is already present with identical settings is left as
+// is, one with different settings is a conflict. When one of the targets fails a check none of them is added and
+// a *TargetError is returned.
+func (r *Reflector) AddTargets(targets []*config.Target) error {
+	batch := make(map[string]*config.Target, len(targets))
+	added := make([]*config.Target, 0, len(targets))
+
+	r.RLock()
+
+	for _, target := range targets {
+		if err := validateTarget(target); err != nil {
+			r.RUnlock()
+			return err
+		}
+
+		if other, ok := batch[target.Key()]; ok && !reflect.DeepEqual(other, target) {
+			r.RUnlock()
+			return &TargetError{Target: target.Key(), Check: CheckConflict, Reason: "the target is given twice with different settings"}
+		}
+
+		present, err := r.checkConflict(target)
+		if err != nil {
+			r.RUnlock()
+			return err
+		}
+
+		if _, ok := batch[target.Key()]; !ok && !present {
+			added = append(added, target)
+		}
+
+		batch[target.Key()] = target
+	}
+
+	r.RUnlock()
+
+	mirrors := make([]*Mirror, 0, len(added))
+	closeAll := func() {
+		for _, m := range mirrors {
+			m.Close()
+		}
+	}
+
+	for _, target := range added {
+		mirror, err := NewMirror(target, r.config, r.MirrorFailureChan, r.MirrorExpiredChan, r.templateSendQueue.Clone())
+		if err != nil {
+			closeAll()
+			return &TargetError{Target: target.Key(), Check: CheckConfig, Reason: err.Error()}
+		}
+
+		mirrors = append(mirrors, mirror)
+
+		// Probing happens without holding the lock, it can take a while
+		if err := mirror.preflight(r.config.HealthCheckPath); err != nil {
+			closeAll()
+			return &TargetError{Target: target.Key(), Check: CheckPreflight, Reason: err.Error()}
+		}
+	}
+
 	r.Lock()
 	defer r.Unlock()
 
-	for _, url := range urls {
+	// Check again, targets could have been added while probing
+	for _, mirror := range mirrors {
+		if _, err := r.checkConflict(&mirror.target); err != nil {
+			closeAll()
+			return err
+		}
+	}
+
+	for _, mirror := range mirrors {
+		url := mirror.targetURL
+
+		if _, ok := r.mirrors[url]; ok {
+			// Identical target already present, keep its state
+			mirror.Close()
+			continue
+		}
+
 		log.Printf("Adding '%s' to mirror list.", url)
-		r.mirrors[url] = NewMirror(url, r.config, r.MirrorFailureChan, persistent, r.templateSendQueue.Clone())
+		// Requests reflected while probing never reached the mirror, sync with the epochs sent up to now
+		mirror.sendQueue = r.templateSendQueue.Clone()
+		r.mirrors[url] = mirror
+		mirror.startDelayed()
+	}
+
+	return nil
+}
+
+// CapturesMainResponseBody returns whether one of the mirrors captures IDs from the body of the response of the main
+// target to the request, so the body needs to be kept.
+func (r *Reflector) CapturesMainResponseBody(req *http.Request) bool {
+	r.RLock()
+	defer r.RUnlock()
+
+	for _, mirror := range r.mirrors {
+		if mirror.idMapper != nil && mirror.idMapper.capturesBody(req) {
+			return true
+		}
 	}
+
+	return false
 }
 
 func (r *Reflector) RemoveMirrors(urls []string) {
@@ -88,8 +209,45 @@ func (r *Reflector) RemoveMirrors(urls []string) {
 	defer r.Unlock()
 
 	for _, url := range urls {
-		delete(r.mirrors, url)
+		if mirror, ok := r.mirrors[url]; ok {
+			mirror.Close()
+			mirror.removeDelayed()
+			delete(r.mirrors, url)
+		}
 	}
+
+	for id, key := range r.leases {
+		if _, ok := r.mirrors[key]; !ok {
+			delete(r.leases, id)
+		}
+	}
+}
+
+// TargetsWithLabel returns the configuration of the targets that have the label, by target key.
+func (r *Reflector) TargetsWithLabel(key, value string) map[string]*config.Target {
+	r.RLock()
+	defer r.RUnlock()
+
+	targets := make(map[string]*config.Target)
+
+	for k, mirror := range r.mirrors {
+		if v, ok := mirror.target.Labels[key]; ok && v == value {
+			target := mirror.target
+			targets[k] = &target
+		}
+	}
+
+	return targets
+}
+
+// HasTarget returns whether a target with the key exists.
+func (r *Reflector) HasTarget(key string) bool {
+	r.RLock()
+	defer r.RUnlock()
+
+	_, ok := r.mirrors[key]
+
+	return ok
 }
 
 func (r *Reflector) ListMirrors() []*MirrorStatus {
@@ -107,11 +265,12 @@ func (r *Reflector) ListMirrors() []*MirrorStatus {
 	epoch, requests := r.templateSendQueue.QueueStatus()
 
 	targets[i] = &MirrorStatus{
-		State:          StateAlive,
-		FailingSince:   time.Time{},
-		URL:            "internal-reflector",
-		QueuedRequests: requests,
-		Epoch:          epoch,
+		State:             StateAlive,
+		FailingSince:      time.Time{},
+		URL:               "internal-reflector",
+		QueuedRequests:    requests,
+		Epoch:             epoch,
+		RemainingRequests: Unlimited,
 	}
 
 	return targets
@@ -1,6 +1,9 @@
 package mirror
 
-import "net/http"
+import (
+	"net/http"
+	"time"
+)
 
 type Request struct {
 	originalRequest *http.Request
@@ -10,6 +13,25 @@ type Request struct {
 	epoch uint64
 	// Allow some parallelism based on observed parallelism
 	activeRequests map[uint64]interface{}
+	// Nil when the main target did not respond, e.g. when serving the request panicked
+	main *MainResponse
+	// Nil when the request goes to all targets
+	targetFilter *TargetFilter
+}
+
+// MainResponse describes how the main target responded to the request.
+type MainResponse struct {
+	StatusCode         int
+	Latency            time.Duration
+	ClientDisconnected bool
+	// The Set-Cookie headers of the response, for mapping cookies to those of the mirrors. These are production
+	// sessions, so they are not written to the delay backlog.
+	SetCookies []string `json:"-"`
+	// The Location header and the body (up to MaxCaptureBody) of the response, for capturing IDs. They can hold
+	// personal data, so they are not written to the delay backlog.
+	Location        string `json:"-"`
+	ContentEncoding string `json:"-"`
+	Body            []byte `json:"-"`
 }
 
 func NewRequest(req *http.Request, body []byte, epoch uint64, activeRequests map[uint64]interface{}) *Request {
@@ -20,3 +42,12 @@ func NewRequest(req *http.Request, body []byte, epoch uint64, activeRequests map
 		activeRequests:  activeRequests,
 	}
 }
+
+func (r *Request) SetMainResponse(main *MainResponse) {
+	r.main = main
+}
+
+// SetTargetFilter limits the targets the request is mirrored to.
+func (r *Request) SetTargetFilter(filter *TargetFilter) {
+	r.targetFilter = filter
+}
@@ -0,0 +1,171 @@
+package mirror
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rb3ckers/trafficmirror/internal/config"
+)
+
+const (
+	ResolveBalance = "balance"
+	ResolveFanout  = "fanout"
+)
+
+// Parses curl style --resolve entries (host:port:address) to a map from 'host:port' to 'address:port'.
+func parseResolveOverrides(entries []string) (map[string]string, error) {
+	overrides := make(map[string]string, len(entries))
+
+	for _, entry := range entries {
+		parts := strings.SplitN(entry, ":", 3) //nolint:gomnd
+		if len(parts) != 3 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid resolve entry '%s', expected host:port:address", entry)
+		}
+
+		address := strings.TrimSuffix(strings.TrimPrefix(parts[2], "["), "]")
+		if net.ParseIP(address) == nil {
+			return nil, fmt.Errorf("invalid address in resolve entry '%s'", entry)
+		}
+
+		overrides[net.JoinHostPort(parts[0], parts[1])] = net.JoinHostPort(address, parts[1])
+	}
+
+	return overrides, nil
+}
+
+// Periodically resolves the host of a target. Every resolved address gets its own client, so pooled connections
+// don't stick to a single address when the DNS answer changes or contains multiple addresses.
+type resolver struct {
+	sync.Mutex
+	host      string
+	port      string
+	mode      string
+	interval  time.Duration
+	lookup    func(ctx context.Context, host string) ([]string, error)
+	newClient func(overrides map[string]string) (*http.Client, error)
+	addresses []string
+	clients   map[string]*http.Client
+	next      int
+}
+
+func newResolver(targetURL *url.URL, settings config.DNSSettings, newClient func(overrides map[string]string) (*http.Client, error)) (*resolver, error) {
+	mode := strings.ToLower(settings.Mode)
+	switch mode {
+	case "":
+		mode = ResolveBalance
+	case ResolveBalance, ResolveFanout:
+	default:
+		return nil, fmt.Errorf("invalid dns mode '%s', expected '%s' or '%s'", settings.Mode, ResolveBalance, ResolveFanout)
+	}
+
+	port := targetURL.Port()
+	if port == "" {
+		port = "80"
+		if targetURL.Scheme == "https" {
+			port = "443"
+		}
+	}
+
+	return &resolver{
+		host:      targetURL.Hostname(),
+		port:      port,
+		mode:      mode,
+		interval:  settings.ReResolve,
+		lookup:    net.DefaultResolver.LookupHost,
+		newClient: newClient,
+		clients:   make(map[string]*http.Client),
+	}, nil
+}
+
+func (r *resolver) run(doneCh <-chan struct{}) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		r.refresh()
+
+		select {
+		case <-doneCh:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *resolver) refresh() {
+	ctx, cancel := context.WithTimeout(context.Background(), r.interval)
+	defer cancel()
+
+	addresses, err := r.lookup(ctx, r.host)
+	if err != nil || len(addresses) == 0 {
+		// Keep using the addresses from the previous lookup
+		log.Printf("Failed to resolve '%s': %v", r.host, err)
+		return
+	}
+
+	sort.Strings(addresses)
+
+	r.Lock()
+	defer r.Unlock()
+
+	clients := make(map[string]*http.Client, len(addresses))
+	resolved := make([]string, 0, len(addresses))
+
+	for _, address := range addresses {
+		client, ok := r.clients[address]
+		if !ok {
+			client, err = r.newClient(map[string]string{net.JoinHostPort(r.host, r.port): net.JoinHostPort(address, r.port)})
+			if err != nil {
+				log.Printf("Failed to create client for '%s' (%s): %v", r.host, address, err)
+				continue
+			}
+		}
+
+		clients[address] = client
+		resolved = append(resolved, address)
+	}
+
+	for address, client := range r.clients {
+		if _, ok := clients[address]; !ok {
+			client.CloseIdleConnections()
+		}
+	}
+
+	if strings.Join(resolved, ",") != strings.Join(r.addresses, ",") {
+		log.Printf("Resolved '%s' to %s", r.host, resolved)
+	}
+
+	r.addresses = resolved
+	r.clients = clients
+}
+
+// The clients a request should be sent to: a single one when balancing, all of them when fanning out.
+func (r *resolver) selectClients() []*http.Client {
+	r.Lock()
+	defer r.Unlock()
+
+	if len(r.addresses) == 0 {
+		return nil
+	}
+
+	if r.mode == ResolveFanout {
+		clients := make([]*http.Client, 0, len(r.addresses))
+		for _, address := range r.addresses {
+			clients = append(clients, r.clients[address])
+		}
+
+		return clients
+	}
+
+	r.next = (r.next + 1) % len(r.addresses)
+
+	return []*http.Client{r.clients[r.addresses[r.next]]}
+}
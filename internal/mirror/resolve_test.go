@@ -0,0 +1,150 @@
+package mirror
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/rb3ckers/trafficmirror/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseResolveOverrides(t *testing.T) {
+	overrides, err := parseResolveOverrides([]string{"shadow.internal:8080:10.0.0.1", "shadow.internal:443:[::1]"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"shadow.internal:8080": "10.0.0.1:8080",
+		"shadow.internal:443":  "[::1]:443",
+	}, overrides)
+
+	_, err = parseResolveOverrides([]string{"shadow.internal:10.0.0.1"})
+	assert.Error(t, err)
+}
+
+func TestStaticResolveOverride(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Host", r.Host)
+	}))
+	defer server.Close()
+
+	serverURL, _ := url.Parse(server.URL)
+	overrides, err := parseResolveOverrides([]string{"shadow.internal:" + serverURL.Port() + ":127.0.0.1"})
+	assert.NoError(t, err)
+
+	client, err := newHTTPClient(config.ClientProfile{}, "http", overrides)
+	assert.NoError(t, err)
+
+	resp, err := client.Get("http://shadow.internal:" + serverURL.Port() + "/") //nolint:noctx
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "shadow.internal:"+serverURL.Port(), resp.Header.Get("X-Host"))
+}
+
+func mkResolver(t *testing.T, mode string) *resolver {
+	targetURL, _ := url.Parse("http://shadow.internal:8080")
+
+	r, err := newResolver(targetURL, config.DNSSettings{Mode: mode, ReResolve: 1}, func(overrides map[string]string) (*http.Client, error) {
+		return newHTTPClient(config.ClientProfile{}, "http", overrides)
+	})
+	assert.NoError(t, err)
+
+	r.lookup = func(ctx context.Context, host string) ([]string, error) {
+		return []string{"10.0.0.2", "10.0.0.1"}, nil
+	}
+	r.refresh()
+
+	return r
+}
+
+func TestResolverBalancesOverAddresses(t *testing.T) {
+	r := mkResolver(t, ResolveBalance)
+
+	first := r.selectClients()
+	second := r.selectClients()
+	third := r.selectClients()
+
+	assert.Len(t, first, 1)
+	assert.NotSame(t, first[0], second[0])
+	assert.Same(t, first[0], third[0])
+}
+
+func TestResolverFansOutToAllAddresses(t *testing.T) {
+	r := mkResolver(t, ResolveFanout)
+
+	assert.Len(t, r.selectClients(), 2)
+
+	r.lookup = func(ctx context.Context, host string) ([]string, error) {
+		return []string{"10.0.0.1"}, nil
+	}
+	r.refresh()
+
+	assert.Len(t, r.selectClients(), 1)
+	assert.Equal(t, []string{"10.0.0.1"}, r.addresses)
+}
+
+func TestResolvedClientsKeepStaticOverrides(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	serverURL, _ := url.Parse(server.URL)
+
+	m, err := NewMirror(&config.Target{URL: "http://shadow.internal:8080", DNS: config.DNSSettings{
+		Resolve:   []string{"static.internal:" + serverURL.Port() + ":127.0.0.1"},
+		ReResolve: time.Hour,
+	}}, config.Default(), make(chan string), make(chan string), MakeSendQueue(5))
+	assert.NoError(t, err)
+	defer m.Close()
+
+	client, err := m.resolver.newClient(map[string]string{"shadow.internal:8080": "10.0.0.1:8080"})
+	assert.NoError(t, err)
+
+	resp, err := client.Get("http://static.internal:" + serverURL.Port() + "/") //nolint:noctx
+	assert.NoError(t, err)
+	resp.Body.Close()
+}
+
+func TestFanoutContinuesAfterAFailingAddress(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.2:0")
+	if err != nil {
+		t.Skip("127.0.0.2 is not available")
+	}
+
+	received := make(chan struct{}, 1)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- struct{}{}
+	}))
+	server.Listener = listener
+	server.Start()
+	defer server.Close()
+
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	target := &config.Target{URL: "http://shadow.internal:" + port, DNS: config.DNSSettings{Mode: ResolveFanout, ReResolve: time.Hour}}
+
+	m, err := NewMirror(target, config.Default(), make(chan string), make(chan string), MakeSendQueue(5))
+	assert.NoError(t, err)
+	defer m.Close()
+
+	targetURL, _ := url.Parse(target.URL)
+	m.resolver, err = newResolver(targetURL, target.DNS, m.resolver.newClient)
+	assert.NoError(t, err)
+
+	// Nothing listens on 127.0.0.1, which is tried first
+	m.resolver.lookup = func(ctx context.Context, host string) ([]string, error) {
+		return []string{"127.0.0.2", "127.0.0.1"}, nil
+	}
+	m.resolver.refresh()
+
+	req := mkRequest(1, []uint64{})
+	req.originalRequest = httptest.NewRequest(http.MethodGet, "/", nil)
+	m.deliver(req)
+
+	select {
+	case <-received:
+	default:
+		t.Fatal("the second address did not receive the request")
+	}
+}
@@ -0,0 +1,101 @@
+package mirror
+
+import (
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rb3ckers/trafficmirror/internal/config"
+)
+
+const (
+	defaultRetryBackoff    = 100 * time.Millisecond
+	defaultRetryMaxBackoff = 5 * time.Second
+)
+
+var (
+	idempotentMethods = []string{http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete, http.MethodTrace}
+	retryStatusCodes  = []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout}
+)
+
+type retryPolicy struct {
+	maxAttempts int
+	backoff     time.Duration
+	maxBackoff  time.Duration
+	methods     map[string]interface{}
+	statusCodes map[int]interface{}
+}
+
+func newRetryPolicy(cfg config.RetryPolicy) *retryPolicy {
+	p := &retryPolicy{
+		maxAttempts: cfg.MaxAttempts,
+		backoff:     cfg.Backoff,
+		maxBackoff:  cfg.MaxBackoff,
+		methods:     make(map[string]interface{}),
+		statusCodes: make(map[int]interface{}),
+	}
+
+	if p.maxAttempts < 1 {
+		p.maxAttempts = 1
+	}
+
+	if p.backoff <= 0 {
+		p.backoff = defaultRetryBackoff
+	}
+
+	if p.maxBackoff <= 0 {
+		p.maxBackoff = defaultRetryMaxBackoff
+	}
+
+	methods := cfg.Methods
+	if len(methods) == 0 {
+		methods = idempotentMethods
+	}
+
+	for _, method := range methods {
+		p.methods[strings.ToUpper(method)] = nil
+	}
+
+	statusCodes := cfg.StatusCodes
+	if len(statusCodes) == 0 {
+		statusCodes = retryStatusCodes
+	}
+
+	for _, code := range statusCodes {
+		p.statusCodes[code] = nil
+	}
+
+	return p
+}
+
+// Number of attempts a request with the given method gets
+func (p *retryPolicy) attempts(method string) int {
+	if _, ok := p.methods[method]; !ok {
+		return 1
+	}
+
+	return p.maxAttempts
+}
+
+func (p *retryPolicy) retryStatus(statusCode int) bool {
+	_, ok := p.statusCodes[statusCode]
+	return ok
+}
+
+// Exponential backoff before the given retry (starting at 1), with jitter in the upper half of the interval.
+func (p *retryPolicy) backoffFor(retry int) time.Duration {
+	backoff := p.backoff
+
+	for i := 1; i < retry && backoff < p.maxBackoff; i++ {
+		backoff *= 2
+	}
+
+	if backoff > p.maxBackoff {
+		backoff = p.maxBackoff
+	}
+
+	half := backoff / 2 //nolint:gomnd
+
+	return half + time.Duration(rand.Int63n(int64(half)+1)) //nolint:gosec
+}
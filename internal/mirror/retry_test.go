@@ -0,0 +1,75 @@
+package mirror
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rb3ckers/trafficmirror/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryPolicyDefaults(t *testing.T) {
+	p := newRetryPolicy(config.RetryPolicy{MaxAttempts: 3})
+
+	assert.Equal(t, 3, p.attempts(http.MethodGet))
+	assert.Equal(t, 3, p.attempts(http.MethodPut))
+	assert.Equal(t, 1, p.attempts(http.MethodPost))
+	assert.True(t, p.retryStatus(http.StatusServiceUnavailable))
+	assert.False(t, p.retryStatus(http.StatusInternalServerError))
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := newRetryPolicy(config.RetryPolicy{MaxAttempts: 5, Backoff: 100 * time.Millisecond, MaxBackoff: 300 * time.Millisecond})
+
+	for i := 0; i < 10; i++ {
+		first := p.backoffFor(1)
+		assert.GreaterOrEqual(t, first, 50*time.Millisecond)
+		assert.LessOrEqual(t, first, 100*time.Millisecond)
+
+		capped := p.backoffFor(4)
+		assert.GreaterOrEqual(t, capped, 150*time.Millisecond)
+		assert.LessOrEqual(t, capped, 300*time.Millisecond)
+	}
+}
+
+func TestRetriesKeepOrderingSlot(t *testing.T) {
+	var requests int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	target := &config.Target{
+		URL:   server.URL,
+		Retry: config.RetryPolicy{MaxAttempts: 3, Backoff: 10 * time.Millisecond},
+	}
+
+	m, err := NewMirror(target, config.Default(), make(chan string), make(chan string), MakeSendQueue(5))
+	assert.NoError(t, err)
+	defer m.Close()
+
+	req := mkRequest(1, []uint64{})
+	req.originalRequest = httptest.NewRequest(http.MethodGet, "/", nil)
+
+	m.Reflect(req)
+
+	// While retrying the epoch is not completed
+	epoch, _ := m.sendQueue.QueueStatus()
+	assert.Equal(t, uint64(0), epoch)
+
+	assert.Eventually(t, func() bool {
+		epoch, _ := m.sendQueue.QueueStatus()
+		return epoch == 1
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&requests))
+	assert.Equal(t, StateAlive, m.GetStatus().State)
+}
@@ -130,6 +130,18 @@ func (s *SendQueue) performCompleted(req *Request) {
 	}
 }
 
+// Drops all queued requests, they are regarded as completed.
+func (s *SendQueue) DropQueued() {
+	s.Lock()
+	defer s.Unlock()
+
+	for _, req := range s.requestsQueued {
+		s.performCompleted(req)
+	}
+
+	s.requestsQueued = nil
+}
+
 func (s *SendQueue) QueueStatus() (uint64, int) {
 	s.Lock()
 	defer s.Unlock()
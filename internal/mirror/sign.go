@@ -0,0 +1,404 @@
+package mirror
+
+import (
+	"crypto/hmac"
+	"crypto/sha1" //nolint:gosec
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rb3ckers/trafficmirror/internal/config"
+)
+
+const (
+	SignHMAC    = "hmac"
+	SignAWSV4   = "aws-sigv4"
+	defaultSign = "{method}\n{uri}\n{timestamp}\n{body}"
+)
+
+var placeholderPattern = regexp.MustCompile(`\{([a-z0-9-]+(?::[^{}]+)?)\}`)
+
+var hmacPlaceholders = map[string]bool{
+	"method":      true,
+	"host":        true,
+	"path":        true,
+	"query":       true,
+	"uri":         true,
+	"timestamp":   true,
+	"body":        true,
+	"body-sha256": true,
+}
+
+// Signs a mirrored request, after all other changes to the request are made
+type signer interface {
+	sign(req *http.Request, body []byte) error
+}
+
+func newSigner(cfg config.Signing) (signer, error) {
+	switch strings.ToLower(cfg.Scheme) {
+	case "":
+		return nil, nil
+	case SignHMAC:
+		return newHMACSigner(cfg)
+	case SignAWSV4:
+		return newSigV4Signer(cfg)
+	default:
+		return nil, fmt.Errorf("invalid signing scheme '%s', expected '%s' or '%s'", cfg.Scheme, SignHMAC, SignAWSV4)
+	}
+}
+
+// Signs a template over parts of the request with a shared secret, like webhook signatures
+type hmacSigner struct {
+	secret          *secretFile
+	header          string
+	template        string
+	format          string
+	timestampHeader string
+	hash            func() hash.Hash
+	encode          func([]byte) string
+	now             func() time.Time
+}
+
+func newHMACSigner(cfg config.Signing) (*hmacSigner, error) {
+	if cfg.SecretFile == "" {
+		return nil, fmt.Errorf("HMAC signing needs a secret file")
+	}
+
+	s := &hmacSigner{
+		header:          cfg.Header,
+		template:        cfg.Template,
+		format:          cfg.Format,
+		timestampHeader: cfg.TimestampHeader,
+		now:             time.Now,
+	}
+
+	if s.header == "" {
+		s.header = "X-Signature"
+	}
+
+	if s.template == "" {
+		s.template = defaultSign
+	}
+
+	if s.format == "" {
+		s.format = "{signature}"
+	}
+
+	if err := checkPlaceholders(s.template, false); err != nil {
+		return nil, err
+	}
+
+	if err := checkPlaceholders(s.format, true); err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(cfg.Hash) {
+	case "", "sha256":
+		s.hash = sha256.New
+	case "sha1":
+		s.hash = sha1.New
+	case "sha512":
+		s.hash = sha512.New
+	default:
+		return nil, fmt.Errorf("invalid hash '%s', expected 'sha256', 'sha1' or 'sha512'", cfg.Hash)
+	}
+
+	switch strings.ToLower(cfg.Encoding) {
+	case "", "hex":
+		s.encode = hex.EncodeToString
+	case "base64":
+		s.encode = base64.StdEncoding.EncodeToString
+	default:
+		return nil, fmt.Errorf("invalid encoding '%s', expected 'hex' or 'base64'", cfg.Encoding)
+	}
+
+	var err error
+	if s.secret, err = newSecretFile(cfg.SecretFile); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func checkPlaceholders(template string, signature bool) error {
+	for _, match := range placeholderPattern.FindAllStringSubmatch(template, -1) {
+		name := match[1]
+		if !hmacPlaceholders[name] && !strings.HasPrefix(name, "header:") && !(signature && name == "signature") {
+			return fmt.Errorf("unknown placeholder '%s'", match[0])
+		}
+	}
+
+	return nil
+}
+
+func (s *hmacSigner) sign(req *http.Request, body []byte) error {
+	timestamp := strconv.FormatInt(s.now().Unix(), 10) //nolint:gomnd
+	if s.timestampHeader != "" {
+		req.Header.Set(s.timestampHeader, timestamp)
+	}
+
+	values := func(name string) string {
+		switch name {
+		case "method":
+			return req.Method
+		case "host":
+			return req.Host
+		case "path":
+			return req.URL.EscapedPath()
+		case "query":
+			return req.URL.RawQuery
+		case "uri":
+			return req.URL.RequestURI()
+		case "timestamp":
+			return timestamp
+		case "body":
+			return string(body)
+		case "body-sha256":
+			sum := sha256.Sum256(body)
+			return hex.EncodeToString(sum[:])
+		}
+
+		return req.Header.Get(strings.TrimPrefix(name, "header:"))
+	}
+
+	mac := hmac.New(s.hash, []byte(s.secret.value()))
+	mac.Write([]byte(render(s.template, values)))
+	signature := s.encode(mac.Sum(nil))
+
+	req.Header.Set(s.header, render(s.format, func(name string) string {
+		if name == "signature" {
+			return signature
+		}
+
+		return values(name)
+	}))
+
+	return nil
+}
+
+func render(template string, values func(string) string) string {
+	return placeholderPattern.ReplaceAllStringFunc(template, func(placeholder string) string {
+		return values(placeholder[1 : len(placeholder)-1])
+	})
+}
+
+// Signs requests with AWS Signature Version 4
+type sigV4Signer struct {
+	region       string
+	service      string
+	accessKeyID  string
+	secret       *secretFile
+	envSecret    string
+	sessionToken string
+	now          func() time.Time
+}
+
+func newSigV4Signer(cfg config.Signing) (*sigV4Signer, error) {
+	s := &sigV4Signer{
+		region:       cfg.Region,
+		service:      strings.ToLower(cfg.Service),
+		accessKeyID:  cfg.AccessKeyID,
+		envSecret:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		now:          time.Now,
+	}
+
+	if s.region == "" || s.service == "" {
+		return nil, fmt.Errorf("AWS signing needs a region and a service")
+	}
+
+	if s.accessKeyID == "" {
+		s.accessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+	}
+
+	if cfg.SecretFile != "" {
+		var err error
+		if s.secret, err = newSecretFile(cfg.SecretFile); err != nil {
+			return nil, err
+		}
+	}
+
+	if s.accessKeyID == "" || (s.secret == nil && s.envSecret == "") {
+		return nil, fmt.Errorf("AWS signing needs an access key ID and a secret access key")
+	}
+
+	return s, nil
+}
+
+func (s *sigV4Signer) secretKey() string {
+	if s.secret != nil {
+		return s.secret.value()
+	}
+
+	return s.envSecret
+}
+
+func (s *sigV4Signer) sign(req *http.Request, body []byte) error {
+	now := s.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	payloadHash := sha256.Sum256(body)
+	encodedPayloadHash := hex.EncodeToString(payloadHash[:])
+
+	// The production signature headers are meaningless for the target
+	req.Header.Del("X-Amz-Security-Token")
+	req.Header.Del("X-Amz-Content-Sha256")
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	if s.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.sessionToken)
+	}
+
+	if s.service == "s3" {
+		req.Header.Set("X-Amz-Content-Sha256", encodedPayloadHash)
+	}
+
+	signedHeaders, canonicalHeaders := s.canonicalHeaders(req)
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		s.canonicalURI(req.URL),
+		canonicalQuery(req.URL.RawQuery),
+		canonicalHeaders,
+		signedHeaders,
+		encodedPayloadHash,
+	}, "\n")
+
+	scope := strings.Join([]string{date, s.region, s.service, "aws4_request"}, "/")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, hex.EncodeToString(requestHash[:])}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey()), date)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, s.service)
+	key = hmacSHA256(key, "aws4_request")
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKeyID, scope, signedHeaders, hex.EncodeToString(hmacSHA256(key, stringToSign))))
+
+	return nil
+}
+
+// Only the host, content type and AWS headers are signed, other headers may be changed on the way to the target
+func (s *sigV4Signer) canonicalHeaders(req *http.Request) (string, string) {
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+
+	headers := map[string]string{"host": host}
+
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if lower == "content-type" || strings.HasPrefix(lower, "x-amz-") {
+			trimmed := make([]string, len(values))
+			for i, value := range values {
+				trimmed[i] = strings.Join(strings.Fields(value), " ")
+			}
+
+			headers[lower] = strings.Join(trimmed, ",")
+		}
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	var canonical strings.Builder
+	for _, name := range names {
+		canonical.WriteString(name + ":" + headers[name] + "\n")
+	}
+
+	return strings.Join(names, ";"), canonical.String()
+}
+
+// S3 paths are encoded once, the paths of other services twice
+func (s *sigV4Signer) canonicalURI(u *url.URL) string {
+	path := u.EscapedPath()
+	if path == "" {
+		return "/"
+	}
+
+	if s.service == "s3" {
+		return path
+	}
+
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = awsEncode(segment)
+	}
+
+	return strings.Join(segments, "/")
+}
+
+func canonicalQuery(rawQuery string) string {
+	values, err := url.ParseQuery(rawQuery)
+	if err != nil || len(values) == 0 {
+		return ""
+	}
+
+	// Sorted by encoded name, then by value
+	encoded := make(map[string][]string, len(values))
+	names := make([]string, 0, len(values))
+
+	for name, vs := range values {
+		encodedName := awsEncode(name)
+		names = append(names, encodedName)
+
+		for _, v := range vs {
+			encoded[encodedName] = append(encoded[encodedName], awsEncode(v))
+		}
+
+		sort.Strings(encoded[encodedName])
+	}
+
+	sort.Strings(names)
+
+	pairs := make([]string, 0, len(names))
+
+	for _, name := range names {
+		for _, v := range encoded[name] {
+			pairs = append(pairs, name+"="+v)
+		}
+	}
+
+	return strings.Join(pairs, "&")
+}
+
+// Percent encodes everything but the unreserved characters of RFC 3986
+func awsEncode(s string) string {
+	var encoded strings.Builder
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '-' || c == '_' || c == '.' || c == '~' {
+			encoded.WriteByte(c)
+		} else {
+			fmt.Fprintf(&encoded, "%%%02X", c)
+		}
+	}
+
+	return encoded.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+
+	return mac.Sum(nil)
+}
@@ -0,0 +1,121 @@
+package mirror
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rb3ckers/trafficmirror/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func mkSigner(t *testing.T, cfg config.Signing) signer {
+	s, err := newSigner(cfg)
+	assert.NoError(t, err)
+
+	return s
+}
+
+func TestNewSignerValidates(t *testing.T) {
+	assert.Nil(t, mkSigner(t, config.Signing{}))
+
+	for _, cfg := range []config.Signing{
+		{Scheme: "rsa"},
+		{Scheme: SignHMAC},
+		{Scheme: SignHMAC, SecretFile: writeFile(t, "secret", "s"), Template: "{method}{nonce}"},
+		{Scheme: SignHMAC, SecretFile: writeFile(t, "secret", "s"), Hash: "md5"},
+		{Scheme: SignAWSV4, Region: "us-east-1"},
+	} {
+		_, err := newSigner(cfg)
+		assert.Error(t, err, cfg)
+	}
+}
+
+func TestHMACSigner(t *testing.T) {
+	s := mkSigner(t, config.Signing{
+		Scheme:          SignHMAC,
+		SecretFile:      writeFile(t, "secret", "shadow-secret\n"),
+		Header:          "X-Hub-Signature",
+		Template:        "{method}\n{uri}\n{header:X-Timestamp}\n{body}",
+		Format:          "t={timestamp},v1={signature}",
+		TimestampHeader: "X-Timestamp",
+	}).(*hmacSigner)
+	s.now = func() time.Time { return time.Unix(1700000000, 0) }
+
+	req := httptest.NewRequest(http.MethodPost, "http://shadow:8080/hooks?id=1", nil)
+	assert.NoError(t, s.sign(req, []byte(`{"event":"paid"}`)))
+
+	mac := hmac.New(sha256.New, []byte("shadow-secret"))
+	mac.Write([]byte("POST\n/hooks?id=1\n1700000000\n{\"event\":\"paid\"}"))
+
+	assert.Equal(t, "1700000000", req.Header.Get("X-Timestamp"))
+	assert.Equal(t, "t=1700000000,v1="+hex.EncodeToString(mac.Sum(nil)), req.Header.Get("X-Hub-Signature"))
+}
+
+// The get-vanilla case of the AWS Signature Version 4 test suite
+func TestSigV4Signer(t *testing.T) {
+	t.Setenv("AWS_SESSION_TOKEN", "")
+
+	s := mkSigner(t, config.Signing{
+		Scheme:      SignAWSV4,
+		Region:      "us-east-1",
+		Service:     "service",
+		AccessKeyID: "AKIDEXAMPLE",
+		SecretFile:  writeFile(t, "secret", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"),
+	}).(*sigV4Signer)
+	s.now = func() time.Time { return time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC) }
+
+	req, err := http.NewRequest(http.MethodGet, "https://example.amazonaws.com/", nil)
+	assert.NoError(t, err)
+
+	// The production signature is replaced
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential=production")
+
+	assert.NoError(t, s.sign(req, nil))
+	assert.Equal(t, "20150830T123600Z", req.Header.Get("X-Amz-Date"))
+	assert.Equal(t, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, "+
+		"SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		req.Header.Get("Authorization"))
+}
+
+func TestCanonicalQuery(t *testing.T) {
+	assert.Equal(t, "", canonicalQuery(""))
+	assert.Equal(t, "a=1&a=2&a-b=3&b=%20%2F", canonicalQuery("b=+%2F&a-b=3&a=2&a=1"))
+}
+
+func TestSignedAfterRedaction(t *testing.T) {
+	received := make(chan *http.Request, 1)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r
+	}))
+	defer server.Close()
+
+	r := NewReflector(config.Default())
+	assert.NoError(t, r.AddTargets([]*config.Target{{
+		URL:    server.URL,
+		Redact: config.RedactRules{FormFields: []string{"token"}},
+		Sign:   config.Signing{Scheme: SignHMAC, SecretFile: writeFile(t, "secret", "shadow-secret"), Template: "{uri}"},
+	}}))
+
+	req := mkRequest(1, []uint64{})
+	req.originalRequest = httptest.NewRequest(http.MethodGet, "/users?token=abc", nil)
+	req.originalRequest.Header.Set("X-Signature", "production")
+
+	r.sendToMirrors(req)
+
+	select {
+	case mirrored := <-received:
+		mac := hmac.New(sha256.New, []byte("shadow-secret"))
+		mac.Write([]byte("/users?token=REDACTED"))
+		assert.Equal(t, hex.EncodeToString(mac.Sum(nil)), mirrored.Header.Get("X-Signature"))
+		assert.False(t, strings.Contains(mirrored.URL.RawQuery, "abc"))
+	case <-time.After(time.Second):
+		t.Fatal("request was not mirrored")
+	}
+}
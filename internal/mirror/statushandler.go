@@ -21,10 +21,19 @@ func RemovingStatusHandler(m *Mirror) MirrorStatusHandler {
 				log.Printf("Temporarily not mirroring to target %s.", name)
 			} else {
 				m.Lock()
-				defer m.Unlock()
-				if !m.firstFailureTime.IsZero() && time.Since(m.firstFailureTime) > m.persistentFailureTimeout {
+				persistent := !m.firstFailureTime.IsZero() && time.Since(m.firstFailureTime) > m.persistentFailureTimeout
+				if persistent {
 					log.Printf("%s is persistently failing.", name)
-					m.failureCh <- m.targetURL
+
+					if m.quarantineEnabled {
+						m.startQuarantine()
+						persistent = false
+					}
+				}
+				m.Unlock()
+
+				if persistent {
+					m.fail()
 				}
 			}
 		case gobreaker.StateHalfOpen:
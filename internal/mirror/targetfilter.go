@@ -0,0 +1,41 @@
+package mirror
+
+import (
+	"strings"
+)
+
+// TargetFilter limits a request to a subset of the targets, either by target key or by label selector.
+type TargetFilter struct {
+	keys     map[string]bool
+	selector Selector
+}
+
+// ParseTargetFilter parses a comma separated list of target URLs, or else a label selector like 'team=a,env!=prod'.
+func ParseTargetFilter(value string) (*TargetFilter, error) {
+	if strings.Contains(value, "://") {
+		filter := &TargetFilter{keys: make(map[string]bool)}
+
+		for _, key := range strings.Split(value, ",") {
+			if key = strings.TrimSpace(key); key != "" {
+				filter.keys[key] = true
+			}
+		}
+
+		return filter, nil
+	}
+
+	selector, err := ParseSelector([]string{value})
+	if err != nil {
+		return nil, err
+	}
+
+	return &TargetFilter{selector: selector}, nil
+}
+
+func (f *TargetFilter) matches(m *Mirror) bool {
+	if f.keys != nil {
+		return f.keys[m.targetURL]
+	}
+
+	return f.selector.Matches(m.target.Labels)
+}
@@ -0,0 +1,75 @@
+package mirror
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rb3ckers/trafficmirror/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTargetFilter(t *testing.T) {
+	var teamA, teamB int32
+
+	serverA := mkCountingServer(&teamA)
+	defer serverA.Close()
+
+	serverB := mkCountingServer(&teamB)
+	defer serverB.Close()
+
+	r := NewReflector(config.Default())
+	assert.NoError(t, r.AddTargets([]*config.Target{
+		{URL: serverA.URL, Labels: map[string]string{"team": "a"}},
+		{URL: serverB.URL, Labels: map[string]string{"team": "b"}},
+	}))
+
+	byLabel, err := ParseTargetFilter("team=a")
+	assert.NoError(t, err)
+
+	req := mkGetRequest(1)
+	req.SetTargetFilter(byLabel)
+	r.sendToMirrors(req)
+
+	byURL, err := ParseTargetFilter(serverB.URL + ", http://unknown:8080")
+	assert.NoError(t, err)
+
+	req = mkGetRequest(2)
+	req.SetTargetFilter(byURL)
+	r.sendToMirrors(req)
+
+	r.sendToMirrors(mkGetRequest(3))
+
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&teamA) == 2 && atomic.LoadInt32(&teamB) == 2
+	}, time.Second, 10*time.Millisecond)
+
+	// Both send queues complete all epochs, the internal reflector is not used by this test
+	assert.Eventually(t, func() bool {
+		statuses := r.ListMirrors()
+		return statuses[0].Epoch == 3 && statuses[1].Epoch == 3
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestFilteredRequestReleasesQueuedRequests(t *testing.T) {
+	var requests int32
+
+	server := mkCountingServer(&requests)
+	defer server.Close()
+
+	r := NewReflector(config.Default())
+	assert.NoError(t, r.AddTargets([]*config.Target{{URL: server.URL, Labels: map[string]string{"team": "a"}}}))
+
+	// Epoch 2 waits for epoch 1, which is not mirrored to the target
+	r.sendToMirrors(mkGetRequest(2))
+
+	byLabel, err := ParseTargetFilter("team=b")
+	assert.NoError(t, err)
+
+	req := mkGetRequest(1)
+	req.SetTargetFilter(byLabel)
+	r.sendToMirrors(req)
+
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&requests) == 1 }, time.Second, 10*time.Millisecond)
+	assert.Eventually(t, func() bool { return r.ListMirrors()[0].QueuedRequests == 0 }, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,188 @@
+package mirror
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/rb3ckers/trafficmirror/internal/config"
+)
+
+// The checks a target has to pass before it is added
+const (
+	CheckURL       = "url"
+	CheckConfig    = "config"
+	CheckConflict  = "conflict"
+	CheckPreflight = "preflight"
+)
+
+const (
+	PreflightHTTP = "http"
+	PreflightTCP  = "tcp"
+)
+
+const preflightTimeout = 5 * time.Second
+
+// TargetError is returned when a target fails one of the checks and is not added.
+type TargetError struct {
+	Target string `json:"target"`
+	Check  string `json:"check"`
+	Reason string `json:"error"`
+}
+
+func (e *TargetError) Error() string {
+	return fmt.Sprintf("target '%s' failed %s check: %s", e.Target, e.Check, e.Reason)
+}
+
+func validateURL(rawURL string) error {
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+
+	if parsedURL.Scheme != "http" && parsedURL.Scheme != "https" {
+		return fmt.Errorf("'%s' must start with http:// or https://", rawURL)
+	}
+
+	if parsedURL.Hostname() == "" {
+		return fmt.Errorf("'%s' has no host", rawURL)
+	}
+
+	if parsedURL.RawQuery != "" || parsedURL.Fragment != "" {
+		return fmt.Errorf("'%s' must not have a query or fragment", rawURL)
+	}
+
+	return nil
+}
+
+func validateTarget(target *config.Target) *TargetError {
+	fail := func(check string, err error) *TargetError {
+		return &TargetError{Target: target.Key(), Check: check, Reason: err.Error()}
+	}
+
+	urls := []string{target.URL}
+
+	if len(target.Members) > 0 {
+		if target.Name == "" {
+			return fail(CheckURL, fmt.Errorf("a target group needs a name"))
+		}
+
+		urls = target.Members
+	}
+
+	for _, u := range urls {
+		if err := validateURL(u); err != nil {
+			return fail(CheckURL, err)
+		}
+	}
+
+	switch strings.ToLower(target.Preflight) {
+	case "", PreflightHTTP, PreflightTCP:
+	default:
+		return fail(CheckConfig, fmt.Errorf("unknown preflight '%s', expected 'http' or 'tcp'", target.Preflight))
+	}
+
+	return nil
+}
+
+// Returns a conflict error when a different target with the same key exists. The second return value tells whether
+// an identical target is already present.
+func (r *Reflector) checkConflict(target *config.Target) (bool, *TargetError) {
+	existing, ok := r.mirrors[target.Key()]
+	if !ok {
+		return false, nil
+	}
+
+	if !reflect.DeepEqual(existing.target, *target) {
+		return false, &TargetError{Target: target.Key(), Check: CheckConflict, Reason: "a target with different settings already exists"}
+	}
+
+	return true, nil
+}
+
+// Probes every endpoint of the mirror, as configured by the preflight setting of the target.
+func (m *Mirror) preflight(healthCheckPath string) error {
+	var endpoints []endpoint
+
+	if m.group != nil {
+		for _, member := range m.group.members {
+			endpoints = append(endpoints, endpoint{url: member.url, client: member.client})
+		}
+	} else {
+		endpoints = append(endpoints, endpoint{url: m.targetURL, client: m.netClient})
+	}
+
+	path := m.target.PreflightPath
+	if path == "" {
+		path = healthCheckPath
+	}
+
+	for _, ep := range endpoints {
+		var err error
+
+		switch strings.ToLower(m.target.Preflight) {
+		case PreflightHTTP:
+			err = preflightHTTP(ep.client, ep.url+path)
+		case PreflightTCP:
+			err = preflightTCP(ep.url)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func preflightHTTP(client *http.Client, url string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), preflightTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	response, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	// Drain the body, but discard it, to make sure connection can be reused
+	io.Copy(io.Discard, response.Body) //nolint:errcheck
+
+	if response.StatusCode < http.StatusOK || response.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("GET %s returned %s", url, response.Status)
+	}
+
+	return nil
+}
+
+func preflightTCP(rawURL string) error {
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+
+	port := parsedURL.Port()
+	if port == "" {
+		port = "80"
+		if parsedURL.Scheme == "https" {
+			port = "443"
+		}
+	}
+
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(parsedURL.Hostname(), port), preflightTimeout)
+	if err != nil {
+		return err
+	}
+
+	return conn.Close()
+}
@@ -0,0 +1,96 @@
+package mirror
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rb3ckers/trafficmirror/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func assertCheckFailed(t *testing.T, err error, check string) {
+	var targetErr *TargetError
+	if assert.True(t, errors.As(err, &targetErr)) {
+		assert.Equal(t, check, targetErr.Check)
+	}
+}
+
+func TestInvalidTargetURLs(t *testing.T) {
+	r := NewReflector(config.Default())
+
+	for _, url := range []string{"localhost:8080", "ftp://localhost", "http://", "http://localhost/?a=b", "htp//typo"} {
+		assertCheckFailed(t, r.AddTargets([]*config.Target{{URL: url}}), CheckURL)
+	}
+
+	assertCheckFailed(t, r.AddTargets([]*config.Target{{Members: []string{"http://a:8080"}}}), CheckURL)
+	assertCheckFailed(t, r.AddTargets([]*config.Target{{Name: "g", Members: []string{"a:8080"}}}), CheckURL)
+	assert.Len(t, r.ListMirrors(), 1)
+}
+
+func TestConflictingTarget(t *testing.T) {
+	r := NewReflector(config.Default())
+	target := &config.Target{URL: "http://localhost:8080", Labels: map[string]string{"team": "a"}}
+
+	assert.NoError(t, r.AddTargets([]*config.Target{target}))
+	existing := r.mirrors[target.Key()]
+
+	// Identical settings keep the existing mirror
+	assert.NoError(t, r.AddTargets([]*config.Target{{URL: "http://localhost:8080", Labels: map[string]string{"team": "a"}}}))
+	assert.Same(t, existing, r.mirrors[target.Key()])
+
+	assertCheckFailed(t, r.AddTargets([]*config.Target{{URL: "http://localhost:8080", Persistent: true}}), CheckConflict)
+	assertCheckFailed(t, r.AddTargets([]*config.Target{
+		{URL: "http://localhost:9090"},
+		{URL: "http://localhost:9090", MaxRequests: 1},
+	}), CheckConflict)
+	assert.False(t, r.HasTarget("http://localhost:9090"))
+}
+
+func TestPreflight(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ready" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	r := NewReflector(config.Default())
+
+	assertCheckFailed(t, r.AddTargets([]*config.Target{{URL: server.URL, Preflight: PreflightHTTP}}), CheckPreflight)
+	assert.NoError(t, r.AddTargets([]*config.Target{{URL: server.URL, Preflight: PreflightHTTP, PreflightPath: "/ready"}}))
+	assert.NoError(t, r.AddTargets([]*config.Target{{Name: "g", Members: []string{server.URL}, Preflight: PreflightTCP}}))
+
+	// Nothing listens on port 1
+	assertCheckFailed(t, r.AddTargets([]*config.Target{{URL: "http://localhost:1", Preflight: PreflightTCP}}), CheckPreflight)
+	assertCheckFailed(t, r.AddTargets([]*config.Target{{URL: "http://localhost:1", Preflight: "ping"}}), CheckConfig)
+	assert.False(t, r.HasTarget("http://localhost:1"))
+}
+
+func TestRequestsReflectedDuringPreflightDoNotBlockTheTarget(t *testing.T) {
+	r := NewReflector(config.Default())
+	probing := make(chan struct{})
+	release := make(chan struct{})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		close(probing)
+		<-release
+	}))
+	defer server.Close()
+
+	added := make(chan error)
+	go func() {
+		added <- r.AddTargets([]*config.Target{{URL: server.URL, Preflight: PreflightHTTP}})
+	}()
+
+	<-probing
+	r.updateTemplateQueue(mkRequest(1, []uint64{}))
+	r.sendToMirrors(mkRequest(1, []uint64{}))
+	close(release)
+	assert.NoError(t, <-added)
+
+	epoch, queued := r.mirrors[server.URL].sendQueue.QueueStatus()
+	assert.Equal(t, uint64(1), epoch)
+	assert.Equal(t, 0, queued)
+}
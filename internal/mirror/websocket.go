@@ -0,0 +1,506 @@
+package mirror
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	WebSocketMirror  = "mirror"
+	WebSocketCompare = "compare"
+)
+
+// Chunks of frames buffered per target, a target that falls further behind is disconnected
+const webSocketBuffer = 256
+
+// How long a message of the target waits for the message of the main target it is compared to
+const webSocketCompareTimeout = 10 * time.Second
+
+// How long the target has to answer the handshake
+const webSocketHandshakeTimeout = 10 * time.Second
+
+const (
+	opcodeContinuation = 0x0
+	opcodeClose        = 0x8
+)
+
+func parseWebSocketMode(mode string) (string, error) {
+	switch strings.ToLower(mode) {
+	case "":
+		return "", nil
+	case WebSocketMirror, WebSocketCompare:
+		return strings.ToLower(mode), nil
+	default:
+		return "", fmt.Errorf("invalid websocket mode '%s', expected '%s' or '%s'", mode, WebSocketMirror, WebSocketCompare)
+	}
+}
+
+// IsWebSocketUpgrade returns whether the request asks to switch to the WebSocket protocol.
+func IsWebSocketUpgrade(req *http.Request) bool {
+	if !strings.EqualFold(req.Header.Get("Upgrade"), "websocket") {
+		return false
+	}
+
+	for _, value := range req.Header.Values("Connection") {
+		for _, token := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// WebSocketSession mirrors the WebSocket connection of a client to the targets that mirror WebSockets. The frames of
+// the client are passed on as they are, so the targets get the subprotocol and extensions the main target agreed to.
+type WebSocketSession struct {
+	streams   []*webSocketStream
+	startOnce sync.Once
+	closeOnce sync.Once
+}
+
+// MirrorWebSocket returns a session for the WebSocket upgrade request, or nil when no target mirrors it.
+func (r *Reflector) MirrorWebSocket(req *http.Request, targetFilter *TargetFilter) *WebSocketSession {
+	r.RLock()
+	defer r.RUnlock()
+
+	if r.mirroringDisabled {
+		return nil
+	}
+
+	session := &WebSocketSession{}
+
+	for _, mirror := range r.mirrors {
+		if mirror.webSocketMode == "" || (targetFilter != nil && !targetFilter.matches(mirror)) {
+			continue
+		}
+
+		if mirror.isQuarantined() || mirror.isPaused() || !mirror.takeRequestBudget() {
+			continue
+		}
+
+		session.streams = append(session.streams, newWebSocketStream(mirror, req))
+	}
+
+	if len(session.streams) == 0 {
+		return nil
+	}
+
+	return session
+}
+
+// Start connects to the targets. The header is the response header of the main target, with the agreed subprotocol
+// and extensions.
+func (s *WebSocketSession) Start(mainHeader http.Header) {
+	s.startOnce.Do(func() {
+		protocol := mainHeader.Get("Sec-WebSocket-Protocol")
+		extensions := mainHeader.Get("Sec-WebSocket-Extensions")
+
+		for _, stream := range s.streams {
+			go stream.run(protocol, extensions)
+		}
+	})
+}
+
+// ClientData passes bytes the client sent to the main target on to the targets.
+func (s *WebSocketSession) ClientData(data []byte) {
+	for _, stream := range s.streams {
+		stream.push(stream.clientCh, data)
+	}
+}
+
+// ServerData passes bytes the main target sent to the client on to the targets that compare messages.
+func (s *WebSocketSession) ServerData(data []byte) {
+	for _, stream := range s.streams {
+		if stream.mainCh != nil {
+			stream.push(stream.mainCh, data)
+		}
+	}
+}
+
+// Close closes the connections to the targets, after passing on what the client sent.
+func (s *WebSocketSession) Close() {
+	s.closeOnce.Do(func() {
+		for _, stream := range s.streams {
+			stream.stop("")
+		}
+	})
+}
+
+// The mirrored connection to a single target
+type webSocketStream struct {
+	mirror   *Mirror
+	req      *http.Request
+	clientCh chan []byte
+	// Nil unless the messages are compared
+	mainCh    chan []byte
+	stopOnce  sync.Once
+	stoppedCh chan struct{}
+}
+
+func newWebSocketStream(mirror *Mirror, req *http.Request) *webSocketStream {
+	s := &webSocketStream{
+		mirror:    mirror,
+		req:       req,
+		clientCh:  make(chan []byte, webSocketBuffer),
+		stoppedCh: make(chan struct{}),
+	}
+
+	if mirror.webSocketMode == WebSocketCompare {
+		s.mainCh = make(chan []byte, webSocketBuffer)
+	}
+
+	return s
+}
+
+// Never blocks, the main connection must not wait for the target
+func (s *webSocketStream) push(ch chan []byte, data []byte) {
+	select {
+	case <-s.stoppedCh:
+		return
+	default:
+	}
+
+	select {
+	case ch <- append([]byte(nil), data...):
+	default:
+		s.stop("it can not keep up")
+	}
+}
+
+func (s *webSocketStream) stop(reason string) {
+	s.stopOnce.Do(func() {
+		if reason != "" {
+			log.Printf("Stopped mirroring WebSocket to %s: %s.", s.mirror.targetURL, reason)
+		}
+
+		close(s.stoppedCh)
+	})
+}
+
+func (s *webSocketStream) run(protocol, extensions string) {
+	conn, ep, err := s.connect(protocol, extensions)
+	if err != nil {
+		s.stop(err.Error())
+		return
+	}
+
+	// A member of a group stays in flight while the connection is open
+	defer s.mirror.completedEndpoint(ep, http.StatusSwitchingProtocols, nil)
+
+	s.mirror.webSocketOpened()
+	defer s.mirror.webSocketClosed()
+
+	defer conn.Close()
+
+	go s.readTarget(conn, extensions)
+
+	for {
+		select {
+		case data := <-s.clientCh:
+			if _, err := conn.Write(data); err != nil {
+				s.stop(err.Error())
+				return
+			}
+		case <-s.mirror.doneCh:
+			return
+		case <-s.stoppedCh:
+			// Pass on what the client sent before the connection closed, like its close frame
+			for {
+				select {
+				case data := <-s.clientCh:
+					if _, err := conn.Write(data); err != nil {
+						return
+					}
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// Opens the WebSocket connection to the target, with the same changes to the handshake as other mirrored requests.
+func (s *webSocketStream) connect(protocol, extensions string) (io.ReadWriteCloser, endpoint, error) {
+	req := &Request{originalRequest: s.req.Clone(context.Background())}
+	header := req.originalRequest.Header
+
+	// Offer the target what the main target agreed to, the frames of the client depend on it
+	setOrDelete(header, "Sec-WebSocket-Protocol", protocol)
+	setOrDelete(header, "Sec-WebSocket-Extensions", extensions)
+
+	endpoints, err := s.mirror.endpoints(req)
+	if err != nil {
+		return nil, endpoint{}, err
+	}
+
+	ep := endpoints[0]
+
+	conn, statusCode, err := s.handshake(req, ep, extensions)
+	if err != nil {
+		s.mirror.completedEndpoint(ep, statusCode, err)
+		return nil, endpoint{}, err
+	}
+
+	return conn, ep, nil
+}
+
+func (s *webSocketStream) handshake(req *Request, ep endpoint, extensions string) (io.ReadWriteCloser, int, error) {
+	handshake, err := s.mirror.newRequest(s.mirror.prepared(req), ep)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Once switched, the connection no longer depends on the context
+	ctx, cancel := context.WithTimeout(context.Background(), webSocketHandshakeTimeout)
+	defer cancel()
+
+	go func() {
+		select {
+		case <-s.stoppedCh:
+		case <-s.mirror.doneCh:
+		case <-ctx.Done():
+		}
+
+		cancel()
+	}()
+
+	// The transport, not the client, the timeout of the client would end the connection
+	transport := ep.client.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	response, err := transport.RoundTrip(handshake.WithContext(ctx))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if response.StatusCode != http.StatusSwitchingProtocols {
+		response.Body.Close()
+		return nil, response.StatusCode, fmt.Errorf("the target answered the handshake with %s", response.Status)
+	}
+
+	conn, ok := response.Body.(io.ReadWriteCloser)
+	if !ok {
+		response.Body.Close()
+		return nil, response.StatusCode, fmt.Errorf("the connection to the target can not be written to")
+	}
+
+	if response.Header.Get("Sec-WebSocket-Extensions") != extensions {
+		conn.Close()
+		return nil, response.StatusCode, fmt.Errorf("the target agreed to other extensions than the main target")
+	}
+
+	return conn, response.StatusCode, nil
+}
+
+func setOrDelete(header http.Header, name, value string) {
+	if value == "" {
+		header.Del(name)
+	} else {
+		header.Set(name, value)
+	}
+}
+
+// Reads the frames of the target, and compares its messages to those of the main target when asked to.
+func (s *webSocketStream) readTarget(conn io.Reader, extensions string) {
+	if s.mainCh == nil || extensions != "" {
+		if s.mainCh != nil {
+			log.Printf("Not comparing WebSocket messages of %s, they are compressed with extensions '%s'.", s.mirror.targetURL, extensions)
+		}
+
+		io.Copy(io.Discard, conn) //nolint:errcheck
+
+		return
+	}
+
+	mainMessages := make(chan []byte, webSocketBuffer)
+
+	go func() {
+		reader := bufio.NewReader(&chanReader{ch: s.mainCh, stoppedCh: s.stoppedCh})
+
+		for {
+			message, err := readMessage(reader)
+			if err != nil {
+				return
+			}
+
+			select {
+			case mainMessages <- message:
+			default:
+				s.stop("the main target is too far ahead to compare messages")
+				return
+			}
+		}
+	}()
+
+	reader := bufio.NewReader(conn)
+
+	for n := 1; ; n++ {
+		message, err := readMessage(reader)
+		if err != nil {
+			return
+		}
+
+		select {
+		case main := <-mainMessages:
+			equal := bytes.Equal(main, message)
+			if !equal {
+				log.Printf("WebSocket message %d of %s differs from the main target.", n, s.mirror.targetURL)
+			}
+
+			s.mirror.webSocketCompared(equal)
+		case <-time.After(webSocketCompareTimeout):
+			log.Printf("WebSocket message %d of %s has no counterpart from the main target.", n, s.mirror.targetURL)
+			s.mirror.webSocketCompared(false)
+		case <-s.stoppedCh:
+			return
+		}
+	}
+}
+
+// Reads the next text or binary message, control frames are skipped.
+func readMessage(r *bufio.Reader) ([]byte, error) {
+	var message []byte
+
+	for {
+		fin, opcode, payload, err := readFrame(r)
+		if err != nil {
+			return nil, err
+		}
+
+		if opcode == opcodeClose {
+			return nil, io.EOF
+		}
+
+		if opcode&0x8 != 0 {
+			// Ping and pong can be sent between the fragments of a message
+			continue
+		}
+
+		if opcode != opcodeContinuation {
+			message = message[:0]
+		}
+
+		if len(message)+len(payload) > MaxCaptureBody {
+			return nil, fmt.Errorf("message too large to compare")
+		}
+
+		message = append(message, payload...)
+
+		if fin {
+			return message, nil
+		}
+	}
+}
+
+func readFrame(r *bufio.Reader) (bool, byte, []byte, error) {
+	header := make([]byte, 2) //nolint:gomnd
+	if _, err := io.ReadFull(r, header); err != nil {
+		return false, 0, nil, err
+	}
+
+	fin := header[0]&0x80 != 0
+	opcode := header[0] & 0x0f
+	masked := header[1]&0x80 != 0
+	length := uint64(header[1] & 0x7f)
+
+	switch length {
+	case 126: //nolint:gomnd
+		extended := make([]byte, 2) //nolint:gomnd
+		if _, err := io.ReadFull(r, extended); err != nil {
+			return false, 0, nil, err
+		}
+
+		length = uint64(binary.BigEndian.Uint16(extended))
+	case 127: //nolint:gomnd
+		extended := make([]byte, 8) //nolint:gomnd
+		if _, err := io.ReadFull(r, extended); err != nil {
+			return false, 0, nil, err
+		}
+
+		length = binary.BigEndian.Uint64(extended)
+	}
+
+	if length > MaxCaptureBody {
+		return false, 0, nil, fmt.Errorf("frame too large to compare")
+	}
+
+	var mask []byte
+
+	if masked {
+		mask = make([]byte, 4) //nolint:gomnd
+		if _, err := io.ReadFull(r, mask); err != nil {
+			return false, 0, nil, err
+		}
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return false, 0, nil, err
+	}
+
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%len(mask)]
+		}
+	}
+
+	return fin, opcode, payload, nil
+}
+
+// Reads the chunks sent on a channel, until the stream stops
+type chanReader struct {
+	ch        <-chan []byte
+	stoppedCh <-chan struct{}
+	buf       []byte
+}
+
+func (r *chanReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		select {
+		case r.buf = <-r.ch:
+		case <-r.stoppedCh:
+			return 0, io.EOF
+		}
+	}
+
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+
+	return n, nil
+}
+
+func (m *Mirror) webSocketOpened() {
+	m.Lock()
+	defer m.Unlock()
+
+	m.webSockets++
+}
+
+func (m *Mirror) webSocketClosed() {
+	m.Lock()
+	defer m.Unlock()
+
+	m.webSockets--
+}
+
+func (m *Mirror) webSocketCompared(equal bool) {
+	m.Lock()
+	defer m.Unlock()
+
+	if !equal {
+		m.webSocketMismatches++
+	}
+}
@@ -0,0 +1,228 @@
+package mirror
+
+import (
+	"bufio"
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rb3ckers/trafficmirror/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	opcodeText = 0x1
+	opcodePing = 0x9
+)
+
+func mkFrame(fin bool, opcode byte, payload string, masked bool) []byte {
+	frame := []byte{opcode, byte(len(payload))}
+	if fin {
+		frame[0] |= 0x80
+	}
+
+	if !masked {
+		return append(frame, payload...)
+	}
+
+	frame[1] |= 0x80
+	mask := []byte{1, 2, 3, 4}
+	frame = append(frame, mask...)
+
+	for i := 0; i < len(payload); i++ {
+		frame = append(frame, payload[i]^mask[i%4])
+	}
+
+	return frame
+}
+
+// A WebSocket server that reports the messages it receives, and echoes them when asked to
+func mkWebSocketServer(t *testing.T, messages chan<- string, handshakes chan<- *http.Request, echo bool) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if handshakes != nil {
+			handshakes <- r
+		}
+
+		conn, rw, err := http.NewResponseController(w).Hijack()
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer conn.Close()
+
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n") //nolint:errcheck
+		rw.Flush()                                                                                              //nolint:errcheck
+
+		for {
+			message, err := readMessage(rw.Reader)
+			if err != nil {
+				return
+			}
+
+			messages <- string(message)
+
+			if echo {
+				conn.Write(mkFrame(true, opcodeText, string(message), false)) //nolint:errcheck
+			}
+		}
+	}))
+}
+
+func mkUpgradeRequest() *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "/ws", nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+
+	return req
+}
+
+func receive(t *testing.T, ch <-chan string) string {
+	select {
+	case message := <-ch:
+		return message
+	case <-time.After(time.Second):
+		t.Fatal("nothing received")
+		return ""
+	}
+}
+
+func TestIsWebSocketUpgrade(t *testing.T) {
+	assert.True(t, IsWebSocketUpgrade(mkUpgradeRequest()))
+
+	req := mkUpgradeRequest()
+	req.Header.Set("Connection", "keep-alive, Upgrade")
+	assert.True(t, IsWebSocketUpgrade(req))
+
+	req.Header.Set("Upgrade", "h2c")
+	assert.False(t, IsWebSocketUpgrade(req))
+
+	assert.False(t, IsWebSocketUpgrade(httptest.NewRequest(http.MethodGet, "/", nil)))
+}
+
+func TestReadMessage(t *testing.T) {
+	var stream bytes.Buffer
+	stream.Write(mkFrame(false, opcodeText, "hel", true))
+	stream.Write(mkFrame(true, opcodePing, "", true))
+	stream.Write(mkFrame(true, opcodeContinuation, "lo", true))
+	stream.Write(mkFrame(true, opcodeText, "world", false))
+	stream.Write(mkFrame(true, opcodeClose, "", false))
+
+	reader := bufio.NewReader(&stream)
+
+	message, err := readMessage(reader)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(message))
+
+	message, err = readMessage(reader)
+	assert.NoError(t, err)
+	assert.Equal(t, "world", string(message))
+
+	_, err = readMessage(reader)
+	assert.Error(t, err)
+}
+
+func TestWebSocketOnlyMirroredToEnabledTargets(t *testing.T) {
+	r := NewReflector(config.Default())
+	assert.NoError(t, r.AddTargets([]*config.Target{{URL: "http://shadow:8080"}}))
+
+	assert.Nil(t, r.MirrorWebSocket(mkUpgradeRequest(), nil))
+
+	_, err := NewMirror(&config.Target{URL: "http://shadow:8080", WebSocket: "tee"}, config.Default(), make(chan string), make(chan string), MakeSendQueue(20))
+	assert.Error(t, err)
+}
+
+func TestWebSocketMirrored(t *testing.T) {
+	messages := make(chan string, 10)
+	handshakes := make(chan *http.Request, 1)
+
+	server := mkWebSocketServer(t, messages, handshakes, false)
+	defer server.Close()
+
+	r := NewReflector(config.Default())
+	assert.NoError(t, r.AddTargets([]*config.Target{{URL: server.URL, WebSocket: WebSocketMirror}}))
+
+	session := r.MirrorWebSocket(mkUpgradeRequest(), nil)
+	assert.NotNil(t, session)
+
+	session.Start(http.Header{"Sec-Websocket-Protocol": {"chat"}})
+	session.ClientData(mkFrame(true, opcodeText, "hello", true))
+
+	handshake := <-handshakes
+	assert.Equal(t, "/ws", handshake.URL.Path)
+	assert.Equal(t, "chat", handshake.Header.Get("Sec-WebSocket-Protocol"))
+	assert.NotEmpty(t, handshake.Header.Get("X-Mirrored-By"))
+
+	assert.Equal(t, "hello", receive(t, messages))
+	assert.Eventually(t, func() bool { return r.ListMirrors()[0].WebSockets == 1 }, time.Second, 10*time.Millisecond)
+
+	session.Close()
+	assert.Eventually(t, func() bool { return r.ListMirrors()[0].WebSockets == 0 }, time.Second, 10*time.Millisecond)
+}
+
+func TestWebSocketCompared(t *testing.T) {
+	messages := make(chan string, 10)
+
+	server := mkWebSocketServer(t, messages, nil, true)
+	defer server.Close()
+
+	r := NewReflector(config.Default())
+	assert.NoError(t, r.AddTargets([]*config.Target{{URL: server.URL, WebSocket: WebSocketCompare}}))
+
+	session := r.MirrorWebSocket(mkUpgradeRequest(), nil)
+	defer session.Close()
+
+	session.Start(http.Header{})
+
+	session.ServerData(mkFrame(true, opcodeText, "hello", false))
+	session.ClientData(mkFrame(true, opcodeText, "hello", true))
+	assert.Equal(t, "hello", receive(t, messages))
+
+	session.ServerData(mkFrame(true, opcodeText, "bye", false))
+	session.ClientData(mkFrame(true, opcodeText, "world", true))
+	assert.Equal(t, "world", receive(t, messages))
+
+	assert.Eventually(t, func() bool { return r.ListMirrors()[0].WebSocketMismatches == 1 }, time.Second, 10*time.Millisecond)
+}
+
+func TestWebSocketToGroupMemberCompletes(t *testing.T) {
+	messages := make(chan string, 10)
+
+	server := mkWebSocketServer(t, messages, nil, false)
+	defer server.Close()
+
+	r := NewReflector(config.Default())
+	assert.NoError(t, r.AddTargets([]*config.Target{{Name: "shadows", Members: []string{server.URL}, WebSocket: WebSocketMirror}}))
+
+	session := r.MirrorWebSocket(mkUpgradeRequest(), nil)
+	session.Start(http.Header{})
+	session.ClientData(mkFrame(true, opcodeText, "hello", true))
+	assert.Equal(t, "hello", receive(t, messages))
+
+	// The member is in flight while the connection is open
+	assert.Equal(t, 1, r.ListMirrors()[0].Members[0].InFlight)
+
+	session.Close()
+	assert.Eventually(t, func() bool { return r.ListMirrors()[0].Members[0].InFlight == 0 }, time.Second, 10*time.Millisecond)
+}
+
+func TestWebSocketHandshakeCancelledWhenSessionCloses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Never answers the handshake
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	r := NewReflector(config.Default())
+	assert.NoError(t, r.AddTargets([]*config.Target{{Name: "shadows", Members: []string{server.URL}, WebSocket: WebSocketMirror}}))
+
+	session := r.MirrorWebSocket(mkUpgradeRequest(), nil)
+	session.Start(http.Header{})
+
+	assert.Eventually(t, func() bool { return r.ListMirrors()[0].Members[0].InFlight == 1 }, time.Second, 10*time.Millisecond)
+
+	session.Close()
+	assert.Eventually(t, func() bool { return r.ListMirrors()[0].Members[0].InFlight == 0 }, time.Second, 10*time.Millisecond)
+}
@@ -1,19 +1,48 @@
 package proxy
 
 import (
+	"crypto/rand"
+	"fmt"
+	"github.com/rb3ckers/trafficmirror/internal/config"
 	"github.com/rb3ckers/trafficmirror/internal/mirror"
+	"log"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"strings"
 	"time"
 )
 
-func ReverseProxyHandler(reflector *mirror.Reflector, url *url.URL, sendDelay time.Duration) func(res http.ResponseWriter, req *http.Request) {
+func ReverseProxyHandler(reflector *mirror.Reflector, url *url.URL, cfg *config.Config) func(res http.ResponseWriter, req *http.Request) {
 	tracker := MakeRequestTracker()
+	sendDelay := time.Duration(cfg.MainTargetDelayMs) * time.Millisecond
 
 	return func(res http.ResponseWriter, req *http.Request) {
 		proxyTo := httputil.NewSingleHostReverseProxy(url)
 
+		correlate(req, cfg.CorrelationHeader)
+
+		// A request that was mirrored before is not mirrored again, to prevent loops
+		mirrored, targetFilter := mirrorControl(req, cfg.ControlHeader, cfg.ControlTargetsHeader)
+		if !mirrored || (cfg.HopHeader != "" && req.Header.Get(cfg.HopHeader) != "") {
+			// Not mirrored at all, so the request does not need an epoch either
+			time.Sleep(sendDelay)
+			proxyTo.ServeHTTP(res, req)
+
+			return
+		}
+
+		if mirror.IsWebSocketUpgrade(req) {
+			// A WebSocket connection is mirrored as a whole, it does not take part in the ordering of requests
+			if session := reflector.MirrorWebSocket(req, targetFilter); session != nil {
+				serveWebSocket(proxyTo, session, res, req)
+			} else {
+				proxyTo.ServeHTTP(res, req)
+			}
+
+			return
+		}
+
 		body := bufferRequest(req)
 
 		// Update the headers to allow for SSL redirection
@@ -31,7 +60,9 @@ func ReverseProxyHandler(reflector *mirror.Reflector, url *url.URL, sendDelay ti
 				// At this point the request has been served to the main target, so we remove this as active request
 				tracker.RequestDone(requestEpoch)
 
-				reflector.IncomingCh <- mirror.NewRequest(req, body, requestEpoch, activeSnapshot)
+				mirrorRequest := mirror.NewRequest(req, body, requestEpoch, activeSnapshot)
+				mirrorRequest.SetTargetFilter(targetFilter)
+				reflector.IncomingCh <- mirrorRequest
 
 				panic(p)
 			}
@@ -39,12 +70,90 @@ func ReverseProxyHandler(reflector *mirror.Reflector, url *url.URL, sendDelay ti
 
 		time.Sleep(sendDelay)
 
+		recorder := &responseRecorder{ResponseWriter: res, captureBody: reflector.CapturesMainResponseBody(req)}
+		start := time.Now()
+
 		// Server the request to main target
-		proxyTo.ServeHTTP(res, req)
+		proxyTo.ServeHTTP(recorder, req)
 
 		// At this point the request has been served to the main target, so we remove this as active request
 		tracker.RequestDone(requestEpoch)
 
-		reflector.IncomingCh <- mirror.NewRequest(req, body, requestEpoch, activeSnapshot)
+		mirrorRequest := mirror.NewRequest(req, body, requestEpoch, activeSnapshot)
+		mirrorRequest.SetTargetFilter(targetFilter)
+		mirrorRequest.SetMainResponse(&mirror.MainResponse{
+			StatusCode:         recorder.status(),
+			Latency:            time.Since(start),
+			ClientDisconnected: req.Context().Err() != nil,
+			SetCookies:         append([]string(nil), res.Header().Values("Set-Cookie")...),
+			Location:           res.Header().Get("Location"),
+			ContentEncoding:    res.Header().Get("Content-Encoding"),
+			Body:               recorder.capturedBody(),
+		})
+
+		reflector.IncomingCh <- mirrorRequest
 	}
 }
+
+// Reads and strips the mirroring control headers of the request. Returns whether the request is mirrored at all, and
+// to which targets.
+func mirrorControl(req *http.Request, controlHeader, controlTargetsHeader string) (bool, *mirror.TargetFilter) {
+	if controlHeader != "" {
+		value := strings.ToLower(strings.TrimSpace(req.Header.Get(controlHeader)))
+		req.Header.Del(controlHeader)
+
+		switch value {
+		case "off", "false", "no", "0":
+			return false, nil
+		}
+	}
+
+	if controlTargetsHeader == "" {
+		return true, nil
+	}
+
+	value := req.Header.Get(controlTargetsHeader)
+	req.Header.Del(controlTargetsHeader)
+
+	if value == "" {
+		return true, nil
+	}
+
+	filter, err := mirror.ParseTargetFilter(value)
+	if err != nil {
+		// The caller wanted to limit the targets, so rather not mirror than mirror to all of them
+		log.Printf("Not mirroring request with invalid %s header: %v", controlTargetsHeader, err)
+		return false, nil
+	}
+
+	return true, filter
+}
+
+// Makes sure the request carries a correlation ID, reusing an existing one, so the main and mirrored requests can
+// be joined.
+func correlate(req *http.Request, correlationHeader string) {
+	if correlationHeader == "" || req.Header.Get(correlationHeader) != "" {
+		return
+	}
+
+	id, err := newCorrelationID()
+	if err != nil {
+		log.Printf("Failed to generate correlation ID: %v", err)
+		return
+	}
+
+	req.Header.Set(correlationHeader, id)
+}
+
+// Generates a random (version 4) UUID
+func newCorrelationID() (string, error) {
+	b := make([]byte, 16) //nolint:gomnd
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	b[6] = (b[6] & 0x0f) | 0x40 //nolint:gomnd
+	b[8] = (b[8] & 0x3f) | 0x80 //nolint:gomnd
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
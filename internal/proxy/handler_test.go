@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/rb3ckers/trafficmirror/internal/config"
+	"github.com/rb3ckers/trafficmirror/internal/mirror"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMirrorControlHeaders(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Mirror", "Off")
+
+	mirrored, _ := mirrorControl(req, "X-Mirror", "X-Mirror-Targets")
+	assert.False(t, mirrored)
+	assert.Empty(t, req.Header.Get("X-Mirror"))
+
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Mirror", "on")
+	req.Header.Set("X-Mirror-Targets", "team=a")
+
+	mirrored, filter := mirrorControl(req, "X-Mirror", "X-Mirror-Targets")
+	assert.True(t, mirrored)
+	assert.NotNil(t, filter)
+	assert.Empty(t, req.Header.Get("X-Mirror-Targets"))
+
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Mirror-Targets", "!")
+
+	mirrored, _ = mirrorControl(req, "X-Mirror", "X-Mirror-Targets")
+	assert.False(t, mirrored)
+}
+
+func TestMirrorControlHeadersDisabled(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Mirror", "off")
+
+	mirrored, filter := mirrorControl(req, "", "")
+	assert.True(t, mirrored)
+	assert.Nil(t, filter)
+	assert.Equal(t, "off", req.Header.Get("X-Mirror"))
+}
+
+func TestCorrelationID(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	correlate(req, "X-Request-Id")
+	assert.Regexp(t, `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`, req.Header.Get("X-Request-Id"))
+
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Request-Id", "existing")
+	correlate(req, "X-Request-Id")
+	assert.Equal(t, "existing", req.Header.Get("X-Request-Id"))
+}
+
+func TestMirroredRequestIsNotMirroredAgain(t *testing.T) {
+	var correlationID string
+
+	main := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		correlationID = r.Header.Get("X-Request-Id")
+	}))
+	defer main.Close()
+
+	cfg := config.Default()
+	cfg.CorrelationHeader = "X-Request-Id"
+
+	mainURL, _ := url.Parse(main.URL)
+	// The reflector is not running, so mirroring the request would block
+	handler := ReverseProxyHandler(mirror.NewReflector(cfg), mainURL, cfg)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Mirrored-By", "other-mirror")
+
+	done := make(chan struct{})
+
+	go func() {
+		handler(httptest.NewRecorder(), req)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("request carrying the hop header was mirrored")
+	}
+
+	assert.NotEmpty(t, correlationID)
+}
@@ -0,0 +1,79 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/rb3ckers/trafficmirror/internal/mirror"
+)
+
+const defaultLease = 60 * time.Second
+
+// Lets ephemeral targets register themselves with a lease that they need to renew:
+//
+//	PUT    /targets/lease?url=<endpoint>&lease=60s  returns the lease ID
+//	PUT    /targets/lease?id=<id>&lease=60s         renews the lease
+//	DELETE /targets/lease?id=<id>                   removes the target
+func (p *Proxy) leaseHandler(res http.ResponseWriter, req *http.Request) {
+	if err := req.ParseForm(); err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	lease, err := durationOption(req.Form, "lease")
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if lease == 0 {
+		lease = defaultLease
+	}
+
+	id := req.Form.Get("id")
+
+	switch {
+	case req.Method == http.MethodPut && id != "":
+		err = p.reflector.RenewLease(id, lease)
+	case req.Method == http.MethodPut:
+		p.addLease(res, req, lease)
+		return
+	case req.Method == http.MethodDelete && id != "":
+		err = p.reflector.ReleaseLease(id)
+	default:
+		http.Error(res, "Expected PUT with 'url' or 'id', or DELETE with 'id'.", http.StatusBadRequest)
+		return
+	}
+
+	if errors.Is(err, mirror.ErrUnknownLease) {
+		http.Error(res, err.Error(), http.StatusNotFound)
+	} else if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+	}
+}
+
+func (p *Proxy) addLease(res http.ResponseWriter, req *http.Request, lease time.Duration) {
+	targetURL := req.Form.Get("url")
+	if targetURL == "" {
+		http.Error(res, "Missing required field: 'url'.", http.StatusBadRequest)
+		return
+	}
+
+	target, err := parseTargetOptions(req.Form, p.cfg.SecretsDir)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	target.URL = targetURL
+
+	id, err := p.reflector.AddLease(target, lease)
+	if err != nil {
+		writeAddError(res, err)
+		return
+	}
+
+	fmt.Fprintln(res, id)
+}
@@ -0,0 +1,68 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// Pauses or resumes the targets given by 'url', 'group' and 'label' via PUT on /targets/pause and /targets/resume.
+func (p *Proxy) pauseHandler(pause bool) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPut {
+			http.Error(res, "Expected PUT.", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if err := req.ParseForm(); err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		keys, err := p.selectedKeys(req.Form)
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if pause {
+			err = p.reflector.Pause(keys)
+		} else {
+			err = p.reflector.Resume(keys)
+		}
+
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusNotFound)
+		}
+	}
+}
+
+// The global kill switch: GET shows whether mirroring is enabled, PUT with 'enabled=false' stops all mirroring.
+func (p *Proxy) mirroringHandler(res http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		if err := req.ParseForm(); err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		enabled, err := strconv.ParseBool(req.Form.Get("enabled"))
+		if err != nil {
+			http.Error(res, "Invalid or missing field: 'enabled'.", http.StatusBadRequest)
+			return
+		}
+
+		p.SetMirroringEnabled(enabled)
+	default:
+		http.Error(res, "Expected GET or PUT.", http.StatusMethodNotAllowed)
+		return
+	}
+
+	fmt.Fprintf(res, "mirroring enabled: %t\n", p.reflector.MirroringEnabled())
+}
+
+// SetMirroringEnabled enables or disables all mirroring, without changing the targets.
+func (p *Proxy) SetMirroringEnabled(enabled bool) {
+	p.reflector.SetMirroringEnabled(enabled)
+}
@@ -3,7 +3,10 @@ package proxy
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
@@ -14,6 +17,7 @@ import (
 	"time"
 
 	"github.com/rb3ckers/trafficmirror/internal/config"
+	"github.com/rb3ckers/trafficmirror/internal/discovery"
 	"github.com/rb3ckers/trafficmirror/internal/mirror"
 	"golang.org/x/net/http2"
 	"golang.org/x/net/http2/h2c"
@@ -24,21 +28,45 @@ type Proxy struct {
 	reflector  *mirror.Reflector
 	waitGroup  *sync.WaitGroup
 	httpServer *http.Server
+	doneCh     chan struct{}
 }
 
 func NewProxy(cfg *config.Config) *Proxy {
 	p := &Proxy{
 		cfg:       cfg,
 		reflector: mirror.NewReflector(cfg),
+		doneCh:    make(chan struct{}),
 	}
 
-	p.reflector.AddMirrors(cfg.Mirrors, true)
+	if err := p.reflector.AddMirrors(cfg.Mirrors, true); err != nil {
+		log.Printf("Failed to add mirrors: %v", err)
+	}
+
+	for i := range cfg.Targets {
+		if err := p.reflector.AddTargets([]*config.Target{&cfg.Targets[i]}); err != nil {
+			log.Printf("Failed to add mirror target: %v", err)
+		}
+	}
 
 	go p.reflector.Reflect()
 
+	p.startDiscovery()
+
 	return p
 }
 
+func (p *Proxy) startDiscovery() {
+	if p.cfg.DiscoveryFile != "" {
+		source := &discovery.FileSource{Path: p.cfg.DiscoveryFile}
+		go discovery.Run(p.reflector, source, time.Duration(p.cfg.DiscoveryFileInterval)*time.Second, p.doneCh)
+	}
+
+	for _, record := range p.cfg.DiscoverySRV {
+		source := discovery.NewSRVSource(record, p.cfg.DiscoverySRVScheme)
+		go discovery.Run(p.reflector, source, time.Duration(p.cfg.DiscoverySRVInterval)*time.Second, p.doneCh)
+	}
+}
+
 func (p *Proxy) Start(ctx context.Context) error {
 	p.waitGroup = &sync.WaitGroup{}
 	p.waitGroup.Add(1)
@@ -63,7 +91,7 @@ func (p *Proxy) Start(ctx context.Context) error {
 		return err
 	}
 
-	mirrorMux.HandleFunc("/", ReverseProxyHandler(p.reflector, url, time.Duration(p.cfg.MainTargetDelayMs)*time.Millisecond))
+	mirrorMux.HandleFunc("/", ReverseProxyHandler(p.reflector, url, p.cfg))
 
 	// start configuration server if needed
 	if p.cfg.TargetsListenAddress != "" {
@@ -98,45 +126,171 @@ func (p *Proxy) Stop() error {
 		panic(err) // failure/timeout shutting down the server gracefully
 	}
 
+	close(p.doneCh)
 	p.reflector.Close()
 
 	return nil
 }
 
 func (p *Proxy) mirrorsHandler(res http.ResponseWriter, req *http.Request) {
+	if err := req.ParseForm(); err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	if req.Method == http.MethodGet {
+		selector, err := mirror.ParseSelector(req.Form["label"])
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if !p.reflector.MirroringEnabled() {
+			fmt.Fprintln(res, "Mirroring is disabled")
+		}
+
 		for _, target := range p.reflector.ListMirrors() {
-			if target.State == mirror.StateAlive {
-				fmt.Fprintf(res, "%s: %s -- queued: %d -- processed: %d\n", target.URL, target.State, target.QueuedRequests, target.Epoch)
-			} else {
-				fmt.Fprintf(res, "%s: %s (since: %s) -- queued: %d -- processed: %d\n", target.URL, target.State, target.FailingSince.UTC().Format(time.RFC3339), target.QueuedRequests, target.Epoch)
+			if len(selector) == 0 || selector.Matches(target.Labels) {
+				writeTargetStatus(res, target)
 			}
 		}
 
 		return
 	}
 
-	if err := req.ParseForm(); err != nil {
-		http.Error(res, err.Error(), http.StatusBadRequest)
+	targetURLs, inForm := req.Form["url"]
+	groupName := req.Form.Get("group")
+
+	if req.Method == http.MethodDelete {
+		keys, err := p.selectedKeys(req.Form)
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		p.reflector.RemoveMirrors(keys)
+
 		return
 	}
 
-	targetURLs, inForm := req.Form["url"]
-
 	if !inForm {
 		http.Error(res, "Missing required field: 'url'.", http.StatusBadRequest)
 		return
 	}
 
-	persistent := req.Form.Has("persistent")
-	if persistent {
-		persistent = strings.ToLower(req.Form.Get("persistent")) == "true"
+	if req.Method == http.MethodPut {
+		options, err := parseTargetOptions(req.Form, p.cfg.SecretsDir)
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var targets []*config.Target
+
+		if groupName != "" {
+			// All urls are members of the one group
+			options.Name = groupName
+			options.Members = targetURLs
+			targets = append(targets, options)
+		} else {
+			for _, targetURL := range targetURLs {
+				target := *options
+				target.URL = targetURL
+				targets = append(targets, &target)
+			}
+		}
+
+		if err := p.reflector.AddTargets(targets); err != nil {
+			writeAddError(res, err)
+			return
+		}
 	}
+}
 
-	if req.Method == http.MethodPut {
-		p.reflector.AddMirrors(targetURLs, persistent)
-	} else if req.Method == http.MethodDelete {
-		p.reflector.RemoveMirrors(targetURLs)
+// The keys of the targets given by 'url', 'group' and the 'label' selector. A selector that matches no targets
+// selects nothing, that is not an error.
+func (p *Proxy) selectedKeys(form url.Values) ([]string, error) {
+	if !form.Has("url") && !form.Has("group") && !form.Has("label") {
+		return nil, fmt.Errorf("missing required field: 'url', 'group' or 'label'")
+	}
+
+	keys := append(append([]string{}, form["url"]...), form["group"]...)
+
+	if form.Has("label") {
+		selector, err := mirror.ParseSelector(form["label"])
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, p.reflector.Select(selector)...)
+	}
+
+	return keys, nil
+}
+
+func writeTargetStatus(res io.Writer, target *mirror.MirrorStatus) {
+	if target.State == mirror.StateAlive {
+		fmt.Fprintf(res, "%s: %s -- queued: %d -- processed: %d", target.URL, target.State, target.QueuedRequests, target.Epoch)
+	} else {
+		fmt.Fprintf(res, "%s: %s (since: %s) -- queued: %d -- processed: %d", target.URL, target.State, target.FailingSince.UTC().Format(time.RFC3339), target.QueuedRequests, target.Epoch)
+	}
+
+	if target.Paused {
+		fmt.Fprint(res, " -- paused")
+	}
+
+	if len(target.Labels) > 0 {
+		fmt.Fprintf(res, " -- labels: %s", mirror.FormatLabels(target.Labels))
+	}
+
+	if len(target.Metadata) > 0 {
+		fmt.Fprintf(res, " -- metadata: %s", mirror.FormatLabels(target.Metadata))
+	}
+
+	if !target.ExpiresAt.IsZero() {
+		fmt.Fprintf(res, " -- expires in: %s", time.Until(target.ExpiresAt).Round(time.Second))
+	}
+
+	if target.RemainingRequests != mirror.Unlimited {
+		fmt.Fprintf(res, " -- remaining requests: %d", target.RemainingRequests)
+	}
+
+	if !target.QuotaResetsAt.IsZero() {
+		fmt.Fprintf(res, " -- quota resets in: %s", time.Until(target.QuotaResetsAt).Round(time.Second))
+	}
+
+	if target.DelayedRequests > 0 {
+		fmt.Fprintf(res, " -- delayed requests: %d", target.DelayedRequests)
+	}
+
+	if target.MappedCookies > 0 {
+		fmt.Fprintf(res, " -- mapped cookies: %d", target.MappedCookies)
+	}
+
+	if target.MappedIDs > 0 {
+		fmt.Fprintf(res, " -- mapped IDs: %d", target.MappedIDs)
+	}
+
+	if target.WebSockets > 0 || target.WebSocketMismatches > 0 {
+		fmt.Fprintf(res, " -- websockets: %d, differing messages: %d", target.WebSockets, target.WebSocketMismatches)
+	}
+
+	if target.Ramp != nil {
+		fmt.Fprintf(res, " -- ramp: %.0f%%", target.Ramp.Percentage)
+
+		if target.Ramp.Aborted {
+			fmt.Fprintf(res, " (aborted because of %s)", target.Ramp.Reason)
+		}
+	}
+
+	fmt.Fprintln(res)
+
+	for _, member := range target.Members {
+		if member.Ejected {
+			fmt.Fprintf(res, "  %s: ejected\n", member.URL)
+		} else {
+			fmt.Fprintf(res, "  %s: in flight: %d\n", member.URL, member.InFlight)
+		}
 	}
 }
 
@@ -183,13 +337,24 @@ func (p *Proxy) setupTargetsMux(targetsMux *http.ServeMux) error {
 		password = p.cfg.Password
 	}
 
+	handle := func(path string, handler http.HandlerFunc) {
+		if username != "" && password != "" {
+			targetsMux.HandleFunc(path, BasicAuth(handler, username, password, "Please provide username and password for changing mirror targets"))
+		} else {
+			targetsMux.HandleFunc(path, handler)
+		}
+	}
+
 	if username != "" && password != "" {
 		log.Printf("/" + p.cfg.TargetsEndpoint + " is basic auth protected, username is '" + username + "'")
-		targetsMux.HandleFunc("/"+p.cfg.TargetsEndpoint, BasicAuth(p.mirrorsHandler, username, password, "Please provide username and password for changing mirror targets"))
-	} else {
-		targetsMux.HandleFunc("/"+p.cfg.TargetsEndpoint, p.mirrorsHandler)
 	}
 
+	handle("/"+p.cfg.TargetsEndpoint, p.mirrorsHandler)
+	handle("/"+p.cfg.TargetsEndpoint+"/lease", p.leaseHandler)
+	handle("/"+p.cfg.TargetsEndpoint+"/pause", p.pauseHandler(true))
+	handle("/"+p.cfg.TargetsEndpoint+"/resume", p.pauseHandler(false))
+	handle("/"+p.cfg.TargetsEndpoint+"/mirroring", p.mirroringHandler)
+
 	if p.cfg.EnablePProf {
 		targetsMux.HandleFunc("/debug/pprof/", pprof.Index)
 		targetsMux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
@@ -200,3 +365,25 @@ func (p *Proxy) setupTargetsMux(targetsMux *http.ServeMux) error {
 
 	return nil
 }
+
+// Writes a failed check of a target as JSON, other errors are plain bad requests.
+func writeAddError(res http.ResponseWriter, err error) {
+	var targetErr *mirror.TargetError
+	if !errors.As(err, &targetErr) {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	status := http.StatusBadRequest
+
+	switch targetErr.Check {
+	case mirror.CheckConflict:
+		status = http.StatusConflict
+	case mirror.CheckPreflight:
+		status = http.StatusBadGateway
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(status)
+	json.NewEncoder(res).Encode(targetErr) //nolint:errcheck
+}
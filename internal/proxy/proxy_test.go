@@ -5,12 +5,14 @@ import (
 	"crypto/tls"
 	"net"
 	"net/http"
+	"net/url"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/rb3ckers/trafficmirror/internal/config"
+	"github.com/rb3ckers/trafficmirror/internal/mirror"
 	"github.com/stretchr/testify/assert"
 	"golang.org/x/net/http2"
 )
@@ -139,3 +141,22 @@ func TestAuth(t *testing.T) {
 	resp.Body.Close()
 	assert.Equal(t, 401, resp.StatusCode)
 }
+
+func TestSelectedKeys(t *testing.T) {
+	p := &Proxy{reflector: mirror.NewReflector(config.Default())}
+	assert.NoError(t, p.reflector.AddTargets([]*config.Target{{URL: "http://shadow:8080", Labels: map[string]string{"team": "a"}}}))
+
+	form, _ := url.ParseQuery("label=team=a")
+	keys, err := p.selectedKeys(form)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"http://shadow:8080"}, keys)
+
+	// Nothing matches, nothing is selected
+	form, _ = url.ParseQuery("label=team=b")
+	keys, err = p.selectedKeys(form)
+	assert.NoError(t, err)
+	assert.Empty(t, keys)
+
+	_, err = p.selectedKeys(url.Values{})
+	assert.Error(t, err)
+}
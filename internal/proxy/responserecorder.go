@@ -0,0 +1,93 @@
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/rb3ckers/trafficmirror/internal/mirror"
+)
+
+// Records the status code of the response of the main target, while passing everything through to the client.
+type responseRecorder struct {
+	http.ResponseWriter
+	statusCode int
+	// Keeps the body when set, as long as it fits in mirror.MaxCaptureBody
+	captureBody bool
+	body        []byte
+	truncated   bool
+	// Wraps the connection when the response is hijacked, e.g. for upgraded connections
+	wrapConn func(net.Conn) net.Conn
+}
+
+func (r *responseRecorder) WriteHeader(statusCode int) {
+	if r.statusCode == 0 {
+		r.statusCode = statusCode
+	}
+
+	r.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if r.statusCode == 0 {
+		r.statusCode = http.StatusOK
+	}
+
+	if r.captureBody && !r.truncated {
+		if len(r.body)+len(b) > mirror.MaxCaptureBody {
+			r.body, r.truncated = nil, true
+		} else {
+			r.body = append(r.body, b...)
+		}
+	}
+
+	return r.ResponseWriter.Write(b)
+}
+
+// The body written to the client, nil when it was not captured or did not fit
+func (r *responseRecorder) capturedBody() []byte {
+	if r.truncated {
+		return nil
+	}
+
+	return r.body
+}
+
+// The status code sent to the client, net/http sends 200 when nothing was written
+func (r *responseRecorder) status() int {
+	if r.statusCode == 0 {
+		return http.StatusOK
+	}
+
+	return r.statusCode
+}
+
+// Unwrap allows http.ResponseController to reach the original writer
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+func (r *responseRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (r *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer does not support hijacking")
+	}
+
+	if r.statusCode == 0 {
+		r.statusCode = http.StatusSwitchingProtocols
+	}
+
+	conn, rw, err := hijacker.Hijack()
+	if err == nil && r.wrapConn != nil {
+		conn = r.wrapConn(conn)
+	}
+
+	return conn, rw, err
+}
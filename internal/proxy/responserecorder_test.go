@@ -0,0 +1,43 @@
+package proxy
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rb3ckers/trafficmirror/internal/mirror"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResponseRecorder(t *testing.T) {
+	res := httptest.NewRecorder()
+	recorder := &responseRecorder{ResponseWriter: res}
+
+	assert.Equal(t, http.StatusOK, recorder.status())
+
+	recorder.WriteHeader(http.StatusServiceUnavailable)
+	recorder.Write([]byte("down")) //nolint:errcheck
+
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.status())
+	assert.Equal(t, http.StatusServiceUnavailable, res.Code)
+
+	// Flushing reaches the underlying writer
+	assert.NoError(t, http.NewResponseController(recorder).Flush())
+	assert.True(t, res.Flushed)
+}
+
+func TestResponseRecorderCapturesBody(t *testing.T) {
+	recorder := &responseRecorder{ResponseWriter: httptest.NewRecorder()}
+	recorder.Write([]byte(`{"id":123}`)) //nolint:errcheck
+	assert.Nil(t, recorder.capturedBody())
+
+	recorder = &responseRecorder{ResponseWriter: httptest.NewRecorder(), captureBody: true}
+	recorder.Write([]byte(`{"id":`)) //nolint:errcheck
+	recorder.Write([]byte(`123}`))   //nolint:errcheck
+	assert.Equal(t, `{"id":123}`, string(recorder.capturedBody()))
+
+	// Too large bodies are not kept at all
+	recorder.Write(bytes.Repeat([]byte(" "), mirror.MaxCaptureBody)) //nolint:errcheck
+	assert.Nil(t, recorder.capturedBody())
+}
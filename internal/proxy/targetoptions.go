@@ -0,0 +1,438 @@
+package proxy
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rb3ckers/trafficmirror/internal/config"
+)
+
+// Parses the target settings that can be passed as form values when adding targets via the targets endpoint. Files
+// with secrets are named relative to the secrets directory, other files can not be read via the endpoint.
+func parseTargetOptions(form url.Values, secretsDir string) (*config.Target, error) {
+	target := &config.Target{}
+
+	if form.Has("persistent") {
+		target.Persistent = strings.ToLower(form.Get("persistent")) == "true"
+	}
+
+	var err error
+
+	if target.Labels, err = keyValueOption(form, "label"); err != nil {
+		return nil, err
+	}
+
+	if target.Metadata, err = keyValueOption(form, "meta"); err != nil {
+		return nil, err
+	}
+
+	target.PauseMode = form.Get("pause-mode")
+
+	if target.TTL, err = durationOption(form, "ttl"); err != nil {
+		return nil, err
+	}
+
+	if target.MaxRequests, err = intOption(form, "max-requests"); err != nil {
+		return nil, err
+	}
+
+	if target.Delay, err = durationOption(form, "delay"); err != nil {
+		return nil, err
+	}
+
+	if target.DelayMaxBacklog, err = intOption(form, "delay-max-backlog"); err != nil {
+		return nil, err
+	}
+
+	if target.DelayConcurrency, err = intOption(form, "delay-concurrency"); err != nil {
+		return nil, err
+	}
+
+	target.WebSocket = form.Get("websocket")
+	target.Preflight = form.Get("preflight")
+	target.PreflightPath = form.Get("preflight-path")
+
+	target.Balance = form.Get("balance")
+	target.HashKey = form.Get("hash-key")
+
+	if target.EjectAfter, err = intOption(form, "eject-after"); err != nil {
+		return nil, err
+	}
+
+	if target.Retry.MaxAttempts, err = intOption(form, "retry-attempts"); err != nil {
+		return nil, err
+	}
+
+	if target.Retry.Backoff, err = durationOption(form, "retry-backoff"); err != nil {
+		return nil, err
+	}
+
+	if target.Retry.MaxBackoff, err = durationOption(form, "retry-max-backoff"); err != nil {
+		return nil, err
+	}
+
+	target.Retry.Methods = listOption(form, "retry-methods")
+
+	if target.Retry.StatusCodes, err = intListOption(form, "retry-status"); err != nil {
+		return nil, err
+	}
+
+	if err = parseClientOptions(form, &target.Client); err != nil {
+		return nil, err
+	}
+
+	if err = parseRateLimitOptions(form, &target.RateLimit); err != nil {
+		return nil, err
+	}
+
+	if err = parseRampOptions(form, &target.Ramp); err != nil {
+		return nil, err
+	}
+
+	if target.Amplify.Copies, err = intOption(form, "amplify"); err != nil {
+		return nil, err
+	}
+
+	target.Amplify.Mode = form.Get("amplify-mode")
+	target.Amplify.Header = form.Get("amplify-header")
+
+	target.MainResponse.Status = listOption(form, "main-status")
+	target.MainResponse.SkipStatus = listOption(form, "main-skip-status")
+
+	if target.MainResponse.SkipDisconnected, err = boolOption(form, "skip-disconnected"); err != nil {
+		return nil, err
+	}
+
+	if target.MainResponse.ForwardHeaders, err = boolOption(form, "forward-main-response"); err != nil {
+		return nil, err
+	}
+
+	target.Redact = config.RedactRules{
+		DropHeaders: listOption(form, "redact-drop-header"),
+		HashHeaders: listOption(form, "redact-hash-header"),
+		JSONPaths:   listOption(form, "redact-json"),
+		FormFields:  listOption(form, "redact-form"),
+		Patterns:    form["redact-pattern"],
+		Mode:        form.Get("redact-mode"),
+		Secret:      form.Get("redact-secret"),
+	}
+
+	target.Credentials = config.Credentials{
+		Mode:      form.Get("credentials"),
+		Header:    form.Get("credentials-header"),
+		Algorithm: form.Get("credentials-algorithm"),
+		KeyID:     form.Get("credentials-key-id"),
+	}
+
+	if target.Credentials.TokenFile, err = secretFileOption(form, "credentials-token-file", secretsDir); err != nil {
+		return nil, err
+	}
+
+	if target.Credentials.KeyFile, err = secretFileOption(form, "credentials-key-file", secretsDir); err != nil {
+		return nil, err
+	}
+
+	if target.Credentials.TTL, err = durationOption(form, "credentials-ttl"); err != nil {
+		return nil, err
+	}
+
+	target.Sign = config.Signing{
+		Scheme:          form.Get("sign"),
+		Header:          form.Get("sign-header"),
+		Template:        form.Get("sign-template"),
+		Format:          form.Get("sign-format"),
+		TimestampHeader: form.Get("sign-timestamp-header"),
+		Hash:            form.Get("sign-hash"),
+		Encoding:        form.Get("sign-encoding"),
+		Region:          form.Get("sign-region"),
+		Service:         form.Get("sign-service"),
+		AccessKeyID:     form.Get("sign-access-key-id"),
+	}
+
+	if target.Sign.SecretFile, err = secretFileOption(form, "sign-secret-file", secretsDir); err != nil {
+		return nil, err
+	}
+
+	if err = parseCookieJarOptions(form, &target.CookieJar); err != nil {
+		return nil, err
+	}
+
+	if err = parseIDMappingOptions(form, &target.IDMapping); err != nil {
+		return nil, err
+	}
+
+	target.DNS.Resolve = listOption(form, "resolve")
+	target.DNS.Mode = form.Get("dns-mode")
+
+	if target.DNS.ReResolve, err = durationOption(form, "re-resolve"); err != nil {
+		return nil, err
+	}
+
+	return target, nil
+}
+
+func parseCookieJarOptions(form url.Values, jar *config.CookieJar) error {
+	var err error
+
+	if jar.Enabled, err = boolOption(form, "cookie-jar"); err != nil {
+		return err
+	}
+
+	jar.Cookies = listOption(form, "cookie-jar-cookies")
+
+	if jar.IdleTimeout, err = durationOption(form, "cookie-jar-idle-timeout"); err != nil {
+		return err
+	}
+
+	jar.MaxEntries, err = intOption(form, "cookie-jar-max-entries")
+
+	return err
+}
+
+// Captures are given as '[METHOD] [/path-prefix] source', e.g. 'POST /orders location' or 'data.id'.
+func parseIDMappingOptions(form url.Values, mapping *config.IDMapping) error {
+	for _, value := range form["id-capture"] {
+		fields := strings.Fields(value)
+		if len(fields) == 0 || len(fields) > 3 { //nolint:gomnd
+			return fmt.Errorf("invalid value for 'id-capture': '%s'", value)
+		}
+
+		capture := config.IDCapture{From: fields[len(fields)-1]}
+
+		for _, field := range fields[:len(fields)-1] {
+			if strings.HasPrefix(field, "/") {
+				capture.Path = field
+			} else {
+				capture.Method = field
+			}
+		}
+
+		mapping.Capture = append(mapping.Capture, capture)
+	}
+
+	mapping.Rewrite = listOption(form, "id-rewrite")
+
+	var err error
+
+	if mapping.IdleTimeout, err = durationOption(form, "id-idle-timeout"); err != nil {
+		return err
+	}
+
+	mapping.MaxEntries, err = intOption(form, "id-max-entries")
+
+	return err
+}
+
+func parseRateLimitOptions(form url.Values, limit *config.RateLimit) error {
+	var err error
+
+	if limit.Requests, err = floatOption(form, "rate-limit"); err != nil {
+		return err
+	}
+
+	if limit.Bytes, err = intOption(form, "rate-limit-bytes"); err != nil {
+		return err
+	}
+
+	limit.Mode = form.Get("rate-limit-mode")
+
+	if limit.HourlyQuota, err = intOption(form, "hourly-quota"); err != nil {
+		return err
+	}
+
+	limit.DailyQuota, err = intOption(form, "daily-quota")
+
+	return err
+}
+
+func parseRampOptions(form url.Values, ramp *config.Ramp) error {
+	var err error
+
+	if ramp.Duration, err = durationOption(form, "ramp"); err != nil {
+		return err
+	}
+
+	if ramp.From, err = floatOption(form, "ramp-from"); err != nil {
+		return err
+	}
+
+	ramp.OnFailure = form.Get("ramp-on-failure")
+	ramp.MaxErrorRate, err = floatOption(form, "ramp-max-error-rate")
+
+	return err
+}
+
+func parseClientOptions(form url.Values, client *config.ClientProfile) error {
+	durations := map[string]*time.Duration{
+		"timeout":                 &client.Timeout,
+		"dial-timeout":            &client.DialTimeout,
+		"tls-handshake-timeout":   &client.TLSHandshakeTimeout,
+		"response-header-timeout": &client.ResponseHeaderTimeout,
+		"idle-conn-timeout":       &client.IdleConnTimeout,
+		"keep-alive":              &client.KeepAlive,
+	}
+
+	for name, value := range durations {
+		d, err := durationOption(form, name)
+		if err != nil {
+			return err
+		}
+
+		*value = d
+	}
+
+	ints := map[string]*int{
+		"max-idle-conns":          &client.MaxIdleConns,
+		"max-idle-conns-per-host": &client.MaxIdleConnsPerHost,
+		"max-conns-per-host":      &client.MaxConnsPerHost,
+	}
+
+	for name, value := range ints {
+		i, err := intOption(form, name)
+		if err != nil {
+			return err
+		}
+
+		*value = i
+	}
+
+	var err error
+	if client.DisableKeepAlives, err = boolOption(form, "disable-keep-alives"); err != nil {
+		return err
+	}
+
+	if client.ProxyFromEnvironment, err = boolOption(form, "proxy-from-environment"); err != nil {
+		return err
+	}
+
+	client.Redirects = form.Get("redirects")
+	client.SourceAddress = form.Get("source-address")
+	client.HTTP2 = form.Get("http2")
+	client.Proxy = form.Get("proxy")
+
+	return nil
+}
+
+// Returns the path of a file in the secrets directory, anything outside of it is rejected.
+func secretFileOption(form url.Values, name, secretsDir string) (string, error) {
+	value := form.Get(name)
+	if value == "" {
+		return "", nil
+	}
+
+	if secretsDir == "" {
+		return "", fmt.Errorf("'%s' can only be set in the config file, unless a secrets directory is configured", name)
+	}
+
+	if !filepath.IsLocal(value) {
+		return "", fmt.Errorf("invalid value '%s' for '%s', expected the name of a file in the secrets directory", value, name)
+	}
+
+	return filepath.Join(secretsDir, value), nil
+}
+
+// Values in the form 'key=value', the option can be passed multiple times
+func keyValueOption(form url.Values, name string) (map[string]string, error) {
+	if !form.Has(name) {
+		return nil, nil
+	}
+
+	values := make(map[string]string)
+
+	for _, kv := range form[name] {
+		parts := strings.SplitN(kv, "=", 2) //nolint:gomnd
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid value for '%s', expected key=value: '%s'", name, kv)
+		}
+
+		values[parts[0]] = parts[1]
+	}
+
+	return values, nil
+}
+
+func boolOption(form url.Values, name string) (bool, error) {
+	if !form.Has(name) {
+		return false, nil
+	}
+
+	value, err := strconv.ParseBool(form.Get(name))
+	if err != nil {
+		return false, fmt.Errorf("invalid value for '%s': %w", name, err)
+	}
+
+	return value, nil
+}
+
+func intOption(form url.Values, name string) (int, error) {
+	if !form.Has(name) {
+		return 0, nil
+	}
+
+	value, err := strconv.Atoi(form.Get(name))
+	if err != nil {
+		return 0, fmt.Errorf("invalid value for '%s': %w", name, err)
+	}
+
+	return value, nil
+}
+
+func floatOption(form url.Values, name string) (float64, error) {
+	if !form.Has(name) {
+		return 0, nil
+	}
+
+	value, err := strconv.ParseFloat(form.Get(name), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value for '%s': %w", name, err)
+	}
+
+	return value, nil
+}
+
+func durationOption(form url.Values, name string) (time.Duration, error) {
+	if !form.Has(name) {
+		return 0, nil
+	}
+
+	value, err := time.ParseDuration(form.Get(name))
+	if err != nil {
+		return 0, fmt.Errorf("invalid value for '%s': %w", name, err)
+	}
+
+	return value, nil
+}
+
+// Comma separated values, the option can also be passed multiple times
+func listOption(form url.Values, name string) []string {
+	var values []string
+
+	for _, value := range form[name] {
+		for _, v := range strings.Split(value, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+	}
+
+	return values
+}
+
+func intListOption(form url.Values, name string) ([]int, error) {
+	var values []int
+
+	for _, v := range listOption(form, name) {
+		value, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for '%s': %w", name, err)
+		}
+
+		values = append(values, value)
+	}
+
+	return values, nil
+}
@@ -0,0 +1,108 @@
+package proxy
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/rb3ckers/trafficmirror/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTargetOptions(t *testing.T) {
+	form, _ := url.ParseQuery("persistent=true&retry-attempts=3&retry-methods=GET,PUT&retry-status=502&retry-status=503&timeout=5s&label=team=payments&label=env=staging&meta=owner=alice")
+
+	target, err := parseTargetOptions(form, "")
+	assert.NoError(t, err)
+	assert.True(t, target.Persistent)
+	assert.Equal(t, 3, target.Retry.MaxAttempts)
+	assert.Equal(t, []string{"GET", "PUT"}, target.Retry.Methods)
+	assert.Equal(t, []int{502, 503}, target.Retry.StatusCodes)
+	assert.Equal(t, 5*time.Second, target.Client.Timeout)
+	assert.Equal(t, map[string]string{"team": "payments", "env": "staging"}, target.Labels)
+	assert.Equal(t, map[string]string{"owner": "alice"}, target.Metadata)
+}
+
+func TestParseTargetOptionsRejectsInvalidValues(t *testing.T) {
+	for _, query := range []string{"retry-attempts=many", "timeout=5", "label=team", "disable-keep-alives=maybe"} {
+		form, _ := url.ParseQuery(query)
+
+		_, err := parseTargetOptions(form, "")
+		assert.Error(t, err, query)
+	}
+}
+
+func TestParseRedactOptions(t *testing.T) {
+	form, _ := url.ParseQuery(`redact-drop-header=Authorization,Cookie&redact-json=user.email&redact-pattern=\d{3},\d{4}&redact-pattern=email&redact-mode=fake`)
+
+	target, err := parseTargetOptions(form, "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Authorization", "Cookie"}, target.Redact.DropHeaders)
+	assert.Equal(t, []string{"user.email"}, target.Redact.JSONPaths)
+	// Patterns are not split on commas, they are regular expressions
+	assert.Equal(t, []string{`\d{3},\d{4}`, "email"}, target.Redact.Patterns)
+	assert.Equal(t, "fake", target.Redact.Mode)
+}
+
+func TestParseCredentialsOptions(t *testing.T) {
+	form, _ := url.ParseQuery("credentials=remint&credentials-algorithm=RS256&credentials-key-file=key.pem&credentials-ttl=10m")
+
+	target, err := parseTargetOptions(form, "/etc/secrets")
+	assert.NoError(t, err)
+	assert.Equal(t, config.Credentials{Mode: "remint", Algorithm: "RS256", KeyFile: "/etc/secrets/key.pem", TTL: 10 * time.Minute}, target.Credentials)
+
+	form, _ = url.ParseQuery("credentials-ttl=soon")
+	_, err = parseTargetOptions(form, "")
+	assert.Error(t, err)
+}
+
+func TestParseSignOptions(t *testing.T) {
+	form, _ := url.ParseQuery("sign=aws-sigv4&sign-region=eu-west-1&sign-service=execute-api&sign-secret-file=aws/secret")
+
+	target, err := parseTargetOptions(form, "/etc/secrets")
+	assert.NoError(t, err)
+	assert.Equal(t, config.Signing{Scheme: "aws-sigv4", Region: "eu-west-1", Service: "execute-api", SecretFile: "/etc/secrets/aws/secret"}, target.Sign)
+}
+
+func TestSecretFilesAreLimitedToTheSecretsDir(t *testing.T) {
+	for _, query := range []string{"credentials-token-file=token", "credentials-key-file=key.pem", "sign-secret-file=secret"} {
+		form, _ := url.ParseQuery(query)
+
+		_, err := parseTargetOptions(form, "")
+		assert.Error(t, err, query)
+	}
+
+	for _, query := range []string{"credentials-token-file=/etc/passwd", "credentials-key-file=../key.pem", "sign-secret-file=a/../../secret"} {
+		form, _ := url.ParseQuery(query)
+
+		_, err := parseTargetOptions(form, "/etc/secrets")
+		assert.Error(t, err, query)
+	}
+}
+
+func TestParseCookieJarOptions(t *testing.T) {
+	form, _ := url.ParseQuery("cookie-jar=true&cookie-jar-cookies=session,csrf&cookie-jar-idle-timeout=1h")
+
+	target, err := parseTargetOptions(form, "")
+	assert.NoError(t, err)
+	assert.Equal(t, config.CookieJar{Enabled: true, Cookies: []string{"session", "csrf"}, IdleTimeout: time.Hour}, target.CookieJar)
+
+	form, _ = url.ParseQuery("cookie-jar=maybe")
+	_, err = parseTargetOptions(form, "")
+	assert.Error(t, err)
+}
+
+func TestParseIDMappingOptions(t *testing.T) {
+	form, _ := url.ParseQuery("id-capture=POST /orders location&id-capture=data.id&id-rewrite=path,json")
+
+	target, err := parseTargetOptions(form, "")
+	assert.NoError(t, err)
+	assert.Equal(t, config.IDMapping{
+		Capture: []config.IDCapture{{Method: "POST", Path: "/orders", From: "location"}, {From: "data.id"}},
+		Rewrite: []string{"path", "json"},
+	}, target.IDMapping)
+
+	form, _ = url.ParseQuery("id-capture=POST /orders location extra")
+	_, err = parseTargetOptions(form, "")
+	assert.Error(t, err)
+}
@@ -0,0 +1,57 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/rb3ckers/trafficmirror/internal/mirror"
+)
+
+// Passes the bytes of a hijacked WebSocket connection on to the mirrors. The reverse proxy copies the response header
+// of the main target before any frames pass, so the session is started on the first bytes.
+type teeConn struct {
+	net.Conn
+	session   *mirror.WebSocketSession
+	header    http.Header
+	startOnce sync.Once
+}
+
+func (c *teeConn) start() {
+	c.startOnce.Do(func() {
+		c.session.Start(c.header)
+	})
+}
+
+// Read receives the frames of the client
+func (c *teeConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.start()
+		c.session.ClientData(p[:n])
+	}
+
+	return n, err
+}
+
+// Write sends the frames of the main target
+func (c *teeConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		c.start()
+		c.session.ServerData(p[:n])
+	}
+
+	return n, err
+}
+
+// Proxies the WebSocket connection to the main target, while mirroring it to the targets of the session.
+func serveWebSocket(proxyTo http.Handler, session *mirror.WebSocketSession, res http.ResponseWriter, req *http.Request) {
+	defer session.Close()
+
+	recorder := &responseRecorder{ResponseWriter: res, wrapConn: func(conn net.Conn) net.Conn {
+		return &teeConn{Conn: conn, session: session, header: res.Header()}
+	}}
+
+	proxyTo.ServeHTTP(recorder, req)
+}
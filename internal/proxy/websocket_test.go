@@ -0,0 +1,130 @@
+package proxy
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/rb3ckers/trafficmirror/internal/config"
+	"github.com/rb3ckers/trafficmirror/internal/mirror"
+	"github.com/stretchr/testify/assert"
+)
+
+func mkMaskedFrame(payload string) []byte {
+	mask := []byte{1, 2, 3, 4}
+	frame := append([]byte{0x81, 0x80 | byte(len(payload))}, mask...)
+
+	for i := 0; i < len(payload); i++ {
+		frame = append(frame, payload[i]^mask[i%4])
+	}
+
+	return frame
+}
+
+// Reads a single short text frame, masked or not
+func readTextFrame(r io.Reader) (string, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return "", err
+	}
+
+	mask := []byte{0, 0, 0, 0}
+	if header[1]&0x80 != 0 {
+		if _, err := io.ReadFull(r, mask); err != nil {
+			return "", err
+		}
+	}
+
+	payload := make([]byte, header[1]&0x7f)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return "", err
+	}
+
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+
+	return string(payload), nil
+}
+
+// A WebSocket server that reports the messages it receives, and echoes them
+func mkEchoWebSocketServer(t *testing.T, messages chan<- string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, rw, err := http.NewResponseController(w).Hijack()
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer conn.Close()
+
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n") //nolint:errcheck
+		rw.Flush()                                                                                              //nolint:errcheck
+
+		for {
+			message, err := readTextFrame(rw)
+			if err != nil {
+				return
+			}
+
+			messages <- message
+
+			conn.Write(append([]byte{0x81, byte(len(message))}, message...)) //nolint:errcheck
+		}
+	}))
+}
+
+func TestWebSocketMirrored(t *testing.T) {
+	mainMessages := make(chan string, 10)
+	main := mkEchoWebSocketServer(t, mainMessages)
+	defer main.Close()
+
+	shadowMessages := make(chan string, 10)
+	shadow := mkEchoWebSocketServer(t, shadowMessages)
+	defer shadow.Close()
+
+	cfg := config.Default()
+	reflector := mirror.NewReflector(cfg)
+	assert.NoError(t, reflector.AddTargets([]*config.Target{{URL: shadow.URL, WebSocket: mirror.WebSocketCompare}}))
+
+	mainURL, _ := url.Parse(main.URL)
+	proxy := httptest.NewServer(http.HandlerFunc(ReverseProxyHandler(reflector, mainURL, cfg)))
+	defer proxy.Close()
+
+	conn, err := net.Dial("tcp", proxy.Listener.Addr().String())
+	assert.NoError(t, err)
+
+	defer conn.Close()
+
+	conn.Write([]byte("GET /ws HTTP/1.1\r\nHost: proxy\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n" + //nolint:errcheck
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n"))
+
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusSwitchingProtocols, response.StatusCode)
+
+	conn.Write(mkMaskedFrame("hello")) //nolint:errcheck
+
+	echo, err := readTextFrame(reader)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", echo)
+
+	for _, messages := range []chan string{mainMessages, shadowMessages} {
+		select {
+		case message := <-messages:
+			assert.Equal(t, "hello", message)
+		case <-time.After(time.Second):
+			t.Fatal("message was not received")
+		}
+	}
+
+	assert.Eventually(t, func() bool { return reflector.ListMirrors()[0].WebSockets == 1 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, 0, reflector.ListMirrors()[0].WebSocketMismatches)
+
+	conn.Close()
+	assert.Eventually(t, func() bool { return reflector.ListMirrors()[0].WebSockets == 0 }, time.Second, 10*time.Millisecond)
+}
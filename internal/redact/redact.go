@@ -0,0 +1,485 @@
+package redact
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/rb3ckers/trafficmirror/internal/config"
+)
+
+const (
+	ModeMask = "mask"
+	ModeFake = "fake"
+)
+
+// Mask replaces redacted values in the mask mode
+const Mask = "REDACTED"
+
+// Size of the generated key of the hashes and fake values
+const secretSize = 32
+
+// Compressed bodies that decode to more than this are not redacted, but dropped
+const maxDecodedBody = 32 << 20
+
+// The built in patterns
+var builtinPatterns = map[string]string{
+	"email": `[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`,
+	"card":  `\b(?:\d[ \-]?){12,18}\d\b`,
+}
+
+// Redactor applies redaction rules to requests. It is safe for concurrent use.
+type Redactor struct {
+	dropHeaders []string
+	hashHeaders []string
+	jsonPaths   [][]string
+	formFields  map[string]bool
+	patterns    []*regexp.Regexp
+	fake        bool
+	secret      []byte
+}
+
+// New creates a redactor for the rules, it returns nil when there are no rules.
+func New(rules config.RedactRules) (*Redactor, error) {
+	r := &Redactor{
+		dropHeaders: rules.DropHeaders,
+		hashHeaders: rules.HashHeaders,
+		formFields:  make(map[string]bool, len(rules.FormFields)),
+		secret:      []byte(rules.Secret),
+	}
+
+	switch strings.ToLower(rules.Mode) {
+	case "", ModeMask:
+	case ModeFake:
+		r.fake = true
+	default:
+		return nil, fmt.Errorf("invalid redact mode '%s', expected '%s' or '%s'", rules.Mode, ModeMask, ModeFake)
+	}
+
+	for _, path := range rules.JSONPaths {
+		if path == "" {
+			return nil, fmt.Errorf("empty JSON path")
+		}
+
+		r.jsonPaths = append(r.jsonPaths, strings.Split(path, "."))
+	}
+
+	for _, field := range rules.FormFields {
+		r.formFields[field] = true
+	}
+
+	for _, pattern := range rules.Patterns {
+		if builtin, ok := builtinPatterns[strings.ToLower(pattern)]; ok {
+			pattern = builtin
+		}
+
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redact pattern '%s': %w", pattern, err)
+		}
+
+		r.patterns = append(r.patterns, re)
+	}
+
+	if len(r.dropHeaders) == 0 && len(r.hashHeaders) == 0 && !r.redactsBody() {
+		return nil, nil
+	}
+
+	if len(r.secret) == 0 {
+		// Without a key hashes and fake values can be reversed by trying likely values. A random key keeps them
+		// consistent for as long as the process runs.
+		r.secret = make([]byte, secretSize)
+		if _, err := rand.Read(r.secret); err != nil {
+			return nil, err
+		}
+	}
+
+	return r, nil
+}
+
+func (r *Redactor) redactsBody() bool {
+	return len(r.jsonPaths) > 0 || len(r.formFields) > 0 || len(r.patterns) > 0
+}
+
+// Header redacts the header in place.
+func (r *Redactor) Header(header http.Header) {
+	for _, name := range r.dropHeaders {
+		header.Del(name)
+	}
+
+	for _, name := range r.hashHeaders {
+		values := header[http.CanonicalHeaderKey(name)]
+		for i, value := range values {
+			values[i] = r.Hash(value)
+		}
+	}
+
+	if len(r.patterns) == 0 {
+		return
+	}
+
+	for _, values := range header {
+		for i, value := range values {
+			values[i] = r.replacePatterns(value)
+		}
+	}
+}
+
+// RequestURI redacts the form fields and patterns in the query string of the request URI.
+func (r *Redactor) RequestURI(requestURI string) string {
+	path, query, found := strings.Cut(requestURI, "?")
+	if !found {
+		return requestURI
+	}
+
+	return path + "?" + r.Query(query)
+}
+
+// Query redacts the form fields and patterns in a query string.
+func (r *Redactor) Query(rawQuery string) string {
+	if rawQuery == "" || (len(r.formFields) == 0 && len(r.patterns) == 0) {
+		return rawQuery
+	}
+
+	values, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		// The parsed values miss the pairs that could not be parsed, redact the pairs one by one instead
+		return r.rawQuery(rawQuery)
+	}
+
+	for name, vs := range values {
+		for i, v := range vs {
+			if r.formFields[name] {
+				vs[i] = r.replace(v)
+			} else {
+				vs[i] = r.replacePatterns(v)
+			}
+		}
+	}
+
+	return values.Encode()
+}
+
+// Redacts a query that can not be parsed pair by pair, keeping the separators. Values that can not be decoded and
+// pairs of which the name can not be decoded are masked, they may hold a form field.
+func (r *Redactor) rawQuery(rawQuery string) string {
+	var redacted strings.Builder
+
+	for rawQuery != "" {
+		pair, separator := rawQuery, ""
+		rawQuery = ""
+
+		if i := strings.IndexAny(pair, "&;"); i >= 0 {
+			pair, separator, rawQuery = pair[:i], pair[i:i+1], pair[i+1:]
+		}
+
+		redacted.WriteString(r.rawPair(pair))
+		redacted.WriteString(separator)
+	}
+
+	return redacted.String()
+}
+
+func (r *Redactor) rawPair(pair string) string {
+	rawName, rawValue, hasValue := strings.Cut(pair, "=")
+
+	name, err := url.QueryUnescape(rawName)
+	if err != nil {
+		return url.QueryEscape(r.replace(pair))
+	}
+
+	if !hasValue {
+		return pair
+	}
+
+	value, err := url.QueryUnescape(rawValue)
+
+	switch {
+	case err != nil:
+		value = r.replace(rawValue)
+	case r.formFields[name]:
+		value = r.replace(value)
+	default:
+		value = r.replacePatterns(value)
+	}
+
+	return rawName + "=" + url.QueryEscape(value)
+}
+
+// Body redacts the body according to its content type. Patterns are only applied to text bodies. Bodies compressed
+// with gzip or deflate are decoded, redacted and compressed again. Bodies with other encodings can not be inspected,
+// nil is returned for them.
+func (r *Redactor) Body(contentType, contentEncoding string, body []byte) []byte {
+	if len(body) == 0 || !r.redactsBody() {
+		return body
+	}
+
+	switch strings.ToLower(contentEncoding) {
+	case "", "identity":
+		return r.plainBody(contentType, body)
+	case "gzip":
+		reader, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil
+		}
+
+		return r.compressedBody(contentType, reader, func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) })
+	case "deflate":
+		reader, err := zlib.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil
+		}
+
+		return r.compressedBody(contentType, reader, func(w io.Writer) io.WriteCloser { return zlib.NewWriter(w) })
+	default:
+		return nil
+	}
+}
+
+func (r *Redactor) compressedBody(contentType string, reader io.Reader, newWriter func(io.Writer) io.WriteCloser) []byte {
+	decoded, err := io.ReadAll(io.LimitReader(reader, maxDecodedBody+1))
+	if err != nil || len(decoded) > maxDecodedBody {
+		return nil
+	}
+
+	var encoded bytes.Buffer
+
+	writer := newWriter(&encoded)
+	if _, err := writer.Write(r.plainBody(contentType, decoded)); err != nil {
+		return nil
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil
+	}
+
+	return encoded.Bytes()
+}
+
+func (r *Redactor) plainBody(contentType string, body []byte) []byte {
+	mediaType, params, _ := mime.ParseMediaType(contentType)
+
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		if len(r.jsonPaths) > 0 {
+			body = r.jsonBody(body)
+		}
+	case mediaType == "application/x-www-form-urlencoded":
+		return []byte(r.Query(string(body)))
+	case mediaType == "multipart/form-data":
+		return r.multipartBody(params["boundary"], body)
+	case mediaType == "" || strings.HasPrefix(mediaType, "text/") || strings.HasSuffix(mediaType, "xml"):
+	default:
+		// Binary content, patterns would corrupt it
+		return body
+	}
+
+	if len(r.patterns) == 0 {
+		return body
+	}
+
+	return []byte(r.replacePatterns(string(body)))
+}
+
+// Redacts the parts of a multipart form, fields are masked by name and the other parts are redacted according to
+// their content type. A form that can not be parsed is dropped, nil is returned for it.
+func (r *Redactor) multipartBody(boundary string, body []byte) []byte {
+	if boundary == "" {
+		return nil
+	}
+
+	var redacted bytes.Buffer
+
+	reader := multipart.NewReader(bytes.NewReader(body), boundary)
+	writer := multipart.NewWriter(&redacted)
+
+	// Keep the boundary of the content type header
+	if err := writer.SetBoundary(boundary); err != nil {
+		return nil
+	}
+
+	for {
+		part, err := reader.NextRawPart()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil
+		}
+
+		switch strings.ToLower(part.Header.Get("Content-Transfer-Encoding")) {
+		case "", "7bit", "8bit", "binary":
+		default:
+			// Encoded parts can not be inspected
+			return nil
+		}
+
+		content, err := io.ReadAll(part)
+		if err != nil {
+			return nil
+		}
+
+		if part.FileName() == "" && r.formFields[part.FormName()] {
+			content = []byte(r.replace(string(content)))
+		} else if content = r.plainBody(part.Header.Get("Content-Type"), content); content == nil {
+			return nil
+		}
+
+		partWriter, err := writer.CreatePart(part.Header)
+		if err != nil {
+			return nil
+		}
+
+		if _, err := partWriter.Write(content); err != nil {
+			return nil
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil
+	}
+
+	return redacted.Bytes()
+}
+
+func (r *Redactor) jsonBody(body []byte) []byte {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+
+	var document interface{}
+	if err := decoder.Decode(&document); err != nil {
+		// Invalid JSON is passed on as is, the patterns still apply
+		return body
+	}
+
+	for _, path := range r.jsonPaths {
+		document = r.maskPath(document, path)
+	}
+
+	redacted, err := json.Marshal(document)
+	if err != nil {
+		return body
+	}
+
+	return redacted
+}
+
+// Masks the values at the path, '*' matches every key of an object or element of an array.
+func (r *Redactor) maskPath(value interface{}, path []string) interface{} {
+	if len(path) == 0 {
+		return r.replaceJSON(value)
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			if path[0] == "*" || path[0] == key {
+				v[key] = r.maskPath(child, path[1:])
+			}
+		}
+	case []interface{}:
+		for i, child := range v {
+			if path[0] == "*" || path[0] == fmt.Sprint(i) {
+				v[i] = r.maskPath(child, path[1:])
+			}
+		}
+	}
+
+	return value
+}
+
+func (r *Redactor) replaceJSON(value interface{}) interface{} {
+	if !r.fake {
+		return Mask
+	}
+
+	switch v := value.(type) {
+	case string:
+		return r.Fake(v)
+	case json.Number:
+		return json.Number(r.fakeNumber(v.String()))
+	default:
+		return Mask
+	}
+}
+
+func (r *Redactor) replacePatterns(s string) string {
+	for _, re := range r.patterns {
+		s = re.ReplaceAllStringFunc(s, r.replace)
+	}
+
+	return s
+}
+
+func (r *Redactor) replace(s string) string {
+	if r.fake {
+		return r.Fake(s)
+	}
+
+	return Mask
+}
+
+func (r *Redactor) mac(value string) []byte {
+	mac := hmac.New(sha256.New, r.secret)
+	mac.Write([]byte(value))
+
+	return mac.Sum(nil)
+}
+
+// Hash returns a keyed hash of the value, equal values get equal hashes.
+func (r *Redactor) Hash(value string) string {
+	return hex.EncodeToString(r.mac(value))
+}
+
+// Fake returns a fake value of the same format: letters are replaced by letters of the same case and digits by
+// digits, everything else is kept. Equal values get equal fake values.
+func (r *Redactor) Fake(value string) string {
+	seed := r.mac(value)
+	fake := []rune(value)
+
+	for i, c := range fake {
+		b := seed[i%len(seed)] ^ byte(i/len(seed))
+
+		switch {
+		case c >= 'a' && c <= 'z':
+			fake[i] = 'a' + rune(b%26) //nolint:gomnd
+		case c >= 'A' && c <= 'Z':
+			fake[i] = 'A' + rune(b%26) //nolint:gomnd
+		case c >= '0' && c <= '9':
+			fake[i] = '0' + rune(b%10) //nolint:gomnd
+		}
+	}
+
+	return string(fake)
+}
+
+// A fake number must stay a valid JSON number, so it can not start with a 0
+func (r *Redactor) fakeNumber(number string) string {
+	fake := []byte(r.Fake(number))
+
+	for i, c := range fake {
+		if c >= '0' && c <= '9' {
+			if c == '0' && i+1 < len(fake) && fake[i+1] >= '0' && fake[i+1] <= '9' {
+				fake[i] = '1'
+			}
+
+			break
+		}
+	}
+
+	return string(fake)
+}
@@ -0,0 +1,163 @@
+package redact
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"testing"
+
+	"github.com/rb3ckers/trafficmirror/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func mkRedactor(t *testing.T, rules config.RedactRules) *Redactor {
+	r, err := New(rules)
+	assert.NoError(t, err)
+	assert.NotNil(t, r)
+
+	return r
+}
+
+func TestNoRules(t *testing.T) {
+	r, err := New(config.RedactRules{Mode: ModeFake})
+	assert.NoError(t, err)
+	assert.Nil(t, r)
+}
+
+func TestInvalidRules(t *testing.T) {
+	_, err := New(config.RedactRules{Patterns: []string{"("}})
+	assert.Error(t, err)
+
+	_, err = New(config.RedactRules{DropHeaders: []string{"Cookie"}, Mode: "scramble"})
+	assert.Error(t, err)
+}
+
+func TestHeaders(t *testing.T) {
+	r := mkRedactor(t, config.RedactRules{DropHeaders: []string{"authorization"}, HashHeaders: []string{"X-User"}, Patterns: []string{"email"}, Secret: "s3cret"})
+
+	header := http.Header{}
+	header.Set("Authorization", "Bearer token")
+	header.Set("X-User", "1234")
+	header.Set("X-Contact", "Mail alice@example.com")
+
+	r.Header(header)
+
+	assert.Empty(t, header.Get("Authorization"))
+	assert.Equal(t, r.Hash("1234"), header.Get("X-User"))
+	assert.NotEqual(t, r.Hash("1234"), mkRedactor(t, config.RedactRules{DropHeaders: []string{"x"}}).Hash("1234"))
+	assert.Equal(t, "Mail REDACTED", header.Get("X-Contact"))
+}
+
+func TestJSONPaths(t *testing.T) {
+	r := mkRedactor(t, config.RedactRules{JSONPaths: []string{"user.email", "cards.*.number", "missing.field"}})
+
+	body := r.Body("application/json; charset=utf-8", "", []byte(`{"user":{"email":"alice@example.com","id":7},"cards":[{"number":"4111111111111111"},{"number":4000}]}`))
+
+	assert.JSONEq(t, `{"user":{"email":"REDACTED","id":7},"cards":[{"number":"REDACTED"},{"number":"REDACTED"}]}`, string(body))
+
+	// Invalid JSON is passed on
+	assert.Equal(t, []byte(`{"user":`), r.Body("application/json", "", []byte(`{"user":`)))
+}
+
+func TestFakeValues(t *testing.T) {
+	r := mkRedactor(t, config.RedactRules{JSONPaths: []string{"email", "amount"}, Mode: ModeFake})
+
+	body := r.Body("application/json", "", []byte(`{"email":"Alice@example.com","amount":1050}`))
+	again := r.Body("application/json", "", []byte(`{"email":"Alice@example.com","amount":1050}`))
+
+	assert.Equal(t, body, again)
+	assert.Regexp(t, `^\{"amount":[1-9][0-9]{3},"email":"[A-Z][a-z]{4}@[a-z]{7}\.[a-z]{3}"\}$`, string(body))
+	assert.NotContains(t, string(body), "Alice@example.com")
+}
+
+func TestFormAndQuery(t *testing.T) {
+	r := mkRedactor(t, config.RedactRules{FormFields: []string{"password"}, Patterns: []string{"card"}})
+
+	body := r.Body("application/x-www-form-urlencoded", "", []byte("user=alice&password=hunter2&card=4111+1111+1111+1111"))
+	assert.Equal(t, "card=REDACTED&password=REDACTED&user=alice", string(body))
+
+	assert.Equal(t, "/login?password=REDACTED&user=bob", r.RequestURI("/login?user=bob&password=hunter2"))
+	assert.Equal(t, "/login", r.RequestURI("/login"))
+}
+
+func TestMalformedQuery(t *testing.T) {
+	r := mkRedactor(t, config.RedactRules{FormFields: []string{"password"}, Patterns: []string{"card"}})
+
+	// Pairs that can not be decoded are masked, the others are still redacted
+	assert.Equal(t, "password=REDACTED&x=REDACTED&card=REDACTED", r.Query("password=hunter2&x=%zz&card=4111+1111+1111+1111"))
+	assert.Equal(t, "REDACTED&user=bob", r.Query("pass%zzword=hunter2&user=bob"))
+
+	body := r.Body("application/x-www-form-urlencoded", "", []byte("password=hunter2&x=%zz"))
+	assert.Equal(t, "password=REDACTED&x=REDACTED", string(body))
+
+	assert.Equal(t, "/login?password=REDACTED;x=1", r.RequestURI("/login?password=hunter2;x=1"))
+}
+
+func TestMultipartForm(t *testing.T) {
+	r := mkRedactor(t, config.RedactRules{FormFields: []string{"password"}, Patterns: []string{"email"}})
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	writer.WriteField("user", "alice@example.com") //nolint:errcheck
+	writer.WriteField("password", "hunter2")       //nolint:errcheck
+	file, _ := writer.CreateFormFile("avatar", "a.png")
+	file.Write([]byte("alice@example.com")) //nolint:errcheck
+	writer.Close()
+
+	redacted := r.Body(writer.FormDataContentType(), "", body.Bytes())
+
+	form, err := multipart.NewReader(bytes.NewReader(redacted), writer.Boundary()).ReadForm(1 << 20)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"REDACTED"}, form.Value["user"])
+	assert.Equal(t, []string{"REDACTED"}, form.Value["password"])
+
+	// Files are binary, patterns would corrupt them
+	avatar, err := form.File["avatar"][0].Open()
+	assert.NoError(t, err)
+	content, _ := io.ReadAll(avatar)
+	assert.Equal(t, "alice@example.com", string(content))
+
+	// Forms that can not be parsed are dropped
+	assert.Nil(t, r.Body("multipart/form-data", "", body.Bytes()))
+	assert.Nil(t, r.Body(writer.FormDataContentType(), "", []byte("--"+writer.Boundary()+"\r\nbroken")))
+}
+
+func TestPatternsOnlyApplyToText(t *testing.T) {
+	r := mkRedactor(t, config.RedactRules{Patterns: []string{"card"}})
+
+	assert.Equal(t, "paid with REDACTED", string(r.Body("text/plain", "", []byte("paid with 4111-1111-1111-1111"))))
+	assert.Equal(t, "4111111111111111", string(r.Body("application/octet-stream", "", []byte("4111111111111111"))))
+}
+
+func TestCompressedBodies(t *testing.T) {
+	r := mkRedactor(t, config.RedactRules{Patterns: []string{"email"}})
+
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	writer.Write([]byte("mail alice@example.com")) //nolint:errcheck
+	writer.Close()
+
+	reader, err := gzip.NewReader(bytes.NewReader(r.Body("text/plain", "gzip", compressed.Bytes())))
+	assert.NoError(t, err)
+
+	decoded, err := io.ReadAll(reader)
+	assert.NoError(t, err)
+	assert.Equal(t, "mail REDACTED", string(decoded))
+
+	// Encodings that can not be decoded can not be redacted either
+	assert.Nil(t, r.Body("text/plain", "br", []byte("mail alice@example.com")))
+	assert.Nil(t, r.Body("text/plain", "gzip", []byte("not gzip")))
+}
+
+func TestGeneratedSecret(t *testing.T) {
+	rules := config.RedactRules{HashHeaders: []string{"X-User"}, Mode: ModeFake}
+
+	// Without a secret the hashes and fake values can not be reproduced elsewhere
+	r := mkRedactor(t, rules)
+	other := mkRedactor(t, rules)
+	assert.Equal(t, r.Hash("1234"), r.Hash("1234"))
+	assert.NotEqual(t, r.Hash("1234"), other.Hash("1234"))
+	assert.NotEqual(t, r.Fake("alice"), other.Fake("alice"))
+}